@@ -0,0 +1,5 @@
+data "remote_command" "kernel" {
+  provider = remote.server1
+
+  command = "uname -r"
+}
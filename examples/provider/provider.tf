@@ -28,5 +28,73 @@ provider "remote" {
     user     = "john"
     password = "password"
     sudo     = true
+
+    # Keep trying to connect for up to five minutes while the host is booting.
+    retry {
+      max_attempts = 20
+      timeout      = 300000
+    }
+  }
+}
+
+# Hosts that are only reachable through a bastion can be reached with 'proxy_jump'.
+# Multiple 'proxy_jump' blocks are connected to in order.
+provider "remote" {
+  alias = "server3"
+
+  conn {
+    host     = "10.1.0.7"
+    user     = "john"
+    password = "password"
+
+    proxy_jump {
+      host     = "bastion.example.com"
+      user     = "john"
+      password = "password"
+    }
+  }
+}
+
+# Privilege escalation other than plain sudo is configured with 'become',
+# such as doas on Alpine or sudo with a password.
+provider "remote" {
+  alias = "server4"
+
+  conn {
+    host     = "10.0.0.21"
+    user     = "john"
+    password = "password"
+
+    # Access files over SFTP as root, instead of with shell commands.
+    sftp_server_path = "/usr/lib/openssh/sftp-server"
+
+    become {
+      method   = "sudo"
+      password = "password"
+    }
+  }
+}
+
+# Hosts can be looked up in an ssh config file, which fills in the hostname,
+# user, port, identity files and jump hosts that are not set in 'conn'.
+provider "remote" {
+  alias = "server5"
+
+  conn {
+    host       = "db1"
+    ssh_config = "~/.ssh/config"
+  }
+}
+
+# Keys in a specific SSH agent, such as the agent of a password manager, are
+# used with 'agent_socket'. 'agent_identity' selects one of its keys.
+provider "remote" {
+  alias = "server6"
+
+  conn {
+    host           = "10.0.0.23"
+    user           = "john"
+    agent_socket   = "~/.1password/agent.sock"
+    agent_identity = "SHA256:jTnpD5dC9Ey2Ck7Rrsx6ghCZl6FbDGPBmNEsavp5i9E"
   }
 }
@@ -0,0 +1,17 @@
+resource "remote_command" "nginx" {
+  provider = remote.server1
+
+  create = "apt-get install -y nginx"
+  delete = "apt-get remove -y nginx"
+  read   = "dpkg -s nginx"
+}
+
+resource "remote_command" "reload_nginx" {
+  provider = remote.server1
+
+  create = "systemctl reload nginx"
+
+  triggers = {
+    config = remote_file.nginx_conf.sha256
+  }
+}
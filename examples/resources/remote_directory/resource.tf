@@ -0,0 +1,28 @@
+resource "remote_directory" "app" {
+  conn {
+    host        = "10.0.0.12"
+    port        = 22
+    user        = "john"
+    private_key = "<ssh private key>"
+    sudo        = true
+  }
+
+  path        = "/opt/app/config"
+  permissions = "0750"
+  owner_name  = "app"
+  group_name  = "app"
+  recursive   = true
+}
+
+resource "remote_file" "app_config" {
+  conn {
+    host        = "10.0.0.12"
+    port        = 22
+    user        = "john"
+    private_key = "<ssh private key>"
+    sudo        = true
+  }
+
+  path    = "${remote_directory.app.path}/app.conf"
+  content = "log_level = info"
+}
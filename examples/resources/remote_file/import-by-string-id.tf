@@ -0,0 +1,9 @@
+# With Terraform 1.5 and later, `terraform plan -generate-config-out=generated.tf`
+# generates the configuration of imported files. The content is stored in
+# 'content', or in 'content_base64' when it is not valid UTF-8. The owner and
+# group are stored by name in 'owner_name' and 'group_name', or by ID in
+# 'owner' and 'group' when the remote host has no name for them.
+import {
+  to = remote_file.hosts
+  id = "10.0.0.12:22:/etc/hosts"
+}
@@ -0,0 +1,6 @@
+# Files are imported by an ID of the form host:port:path, or user@host:port:path.
+# Imported resources have no 'conn' of their own, so the file is read over the
+# 'conn' of the provider, which must connect to the host, port and user in the
+# ID. Use a provider alias with a 'conn' for each host files are imported from.
+terraform import remote_file.sudoers 10.0.0.12:22:/etc/sudoers.d/deploy
+terraform import remote_file.nginx_conf john@10.0.0.12:22:/etc/nginx/nginx.conf
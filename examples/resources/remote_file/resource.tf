@@ -30,3 +30,38 @@ resource "remote_file" "server2_bashrc" {
   owner_name  = "john"
   group_name  = "john"
 }
+
+resource "remote_file" "keystore" {
+  provider = remote.server1
+
+  path           = "/etc/app/keystore.p12"
+  content_base64 = filebase64("${path.module}/keystore.p12")
+  permissions    = "0600"
+}
+
+resource "remote_file" "binary" {
+  provider = remote.server1
+
+  path        = "/usr/local/bin/app"
+  source      = "${path.module}/build/app"
+  permissions = "0755"
+}
+
+resource "remote_file" "sudoers" {
+  provider = remote.server1
+
+  path             = "/etc/sudoers.d/john"
+  content          = "john ALL=(ALL) NOPASSWD: ALL\n"
+  permissions      = "0440"
+  atomic           = true
+  validate_command = "visudo -cf %s"
+}
+
+resource "remote_file" "nginx_conf" {
+  provider = remote.server1
+
+  path              = "/etc/nginx/nginx.conf"
+  content           = file("${path.module}/nginx.conf")
+  on_create_command = "systemctl reload nginx"
+  on_update_command = "systemctl reload nginx"
+}
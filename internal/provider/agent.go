@@ -0,0 +1,117 @@
+package provider
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// sshAgent is a connection to an SSH agent, which is opened when its keys are
+// first needed and kept open until closed, as the keys sign during the
+// handshake of every connection attempt.
+type sshAgent struct {
+	socket string
+	// Fingerprint or comment of the only key offered, or empty to offer all
+	// keys.
+	identity string
+
+	mux  sync.Mutex
+	conn net.Conn
+}
+
+// Signers returns the keys held by the agent, limited to identity when set.
+func (a *sshAgent) Signers() ([]ssh.Signer, error) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	if a.conn == nil {
+		conn, err := net.Dial("unix", a.socket)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't connect to SSH agent: %s", err.Error())
+		}
+		a.conn = conn
+	}
+
+	client := agent.NewClient(a.conn)
+	signers, err := client.Signers()
+	if err != nil || a.identity == "" {
+		return signers, err
+	}
+
+	keys, err := client.List()
+	if err != nil {
+		return nil, err
+	}
+
+	// Offer certificates of the key along with the key itself.
+	var identitySigners []ssh.Signer
+	for _, key := range keys {
+		if !matchAgentIdentity(key, a.identity) {
+			continue
+		}
+		publicKey, err := ssh.ParsePublicKey(key.Marshal())
+		if err != nil {
+			return nil, err
+		}
+		for _, signer := range signers {
+			if bytes.Equal(underlyingKey(signer.PublicKey()).Marshal(), underlyingKey(publicKey).Marshal()) {
+				identitySigners = append(identitySigners, signer)
+			}
+		}
+	}
+	if len(identitySigners) == 0 {
+		return nil, fmt.Errorf("no key in the SSH agent matches agent identity %q", a.identity)
+	}
+	return identitySigners, nil
+}
+
+// Close closes the connection to the agent, if it was opened.
+func (a *sshAgent) Close() error {
+	if a == nil {
+		return nil
+	}
+
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	if a.conn == nil {
+		return nil
+	}
+	err := a.conn.Close()
+	a.conn = nil
+	return err
+}
+
+// underlyingKey returns the key a certificate is signed for, or key itself
+// when it is not a certificate.
+func underlyingKey(key ssh.PublicKey) ssh.PublicKey {
+	if certificate, ok := key.(*ssh.Certificate); ok {
+		return certificate.Key
+	}
+	return key
+}
+
+// matchAgentIdentity returns whether identity is the SHA256 or MD5
+// fingerprint, or the comment, of key. Like ssh-add, the fingerprint of a
+// certificate is the fingerprint of the key it is signed for.
+func matchAgentIdentity(key *agent.Key, identity string) bool {
+	publicKey, err := ssh.ParsePublicKey(key.Marshal())
+	if err != nil {
+		return false
+	}
+	publicKey = underlyingKey(publicKey)
+
+	switch {
+	case strings.HasPrefix(identity, "SHA256:"):
+		return ssh.FingerprintSHA256(publicKey) == identity
+	case strings.HasPrefix(identity, "MD5:"):
+		return ssh.FingerprintLegacyMD5(publicKey) == strings.TrimPrefix(identity, "MD5:")
+	default:
+		return key.Comment == identity
+	}
+}
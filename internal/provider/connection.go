@@ -1,30 +1,157 @@
 package provider
 
 import (
+	"bytes"
+	"cmp"
 	"context"
+	"errors"
 	"fmt"
+	"io/fs"
 	"net"
 	"os"
+	"os/user"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
 	"golang.org/x/crypto/ssh"
-	"golang.org/x/crypto/ssh/agent"
 )
 
 var connectionSchemaResource = &schema.Resource{
+	Schema: connectionSchema(),
+}
+
+var proxyJumpSchemaResource = &schema.Resource{
+	Schema: proxyJumpSchema(),
+}
+
+var becomeSchemaResource = &schema.Resource{
+	Schema: map[string]*schema.Schema{
+		"method": {
+			Type:             schema.TypeString,
+			Optional:         true,
+			Default:          "sudo",
+			ValidateDiagFunc: validation.ToDiagFunc(validation.StringInSlice([]string{"sudo", "doas", "su", "pbrun"}, false)),
+			Description:      "The command used to become another user. One of `sudo`, `doas`, `su` and `pbrun`.",
+		},
+		"user": {
+			Type:        schema.TypeString,
+			Optional:    true,
+			Description: "The user to become. Defaults to the default user of `method`, which is usually `root`.",
+		},
+		"password": {
+			Type:        schema.TypeString,
+			Optional:    true,
+			Sensitive:   true,
+			Description: "The password of the user on the remote host, written to stdin with `sudo -S`. Only supported by `sudo`, and must only be set when sudo asks for a password.",
+		},
+		"flags": {
+			Type:        schema.TypeList,
+			Optional:    true,
+			Elem:        &schema.Schema{Type: schema.TypeString},
+			Description: "Extra flags passed to `method`, such as `[\"-H\"]` for `sudo` or `[\"-l\"]` for `su`.",
+		},
+	},
+}
+
+var retrySchemaResource = &schema.Resource{
 	Schema: map[string]*schema.Schema{
+		"max_attempts": {
+			Type:             schema.TypeInt,
+			Optional:         true,
+			Default:          5,
+			ValidateDiagFunc: validation.ToDiagFunc(validation.IntAtLeast(1)),
+			Description:      "The maximum number of attempts, including the first one.",
+		},
+		"initial_backoff": {
+			Type:        schema.TypeInt,
+			Optional:    true,
+			Default:     1000,
+			Description: "The amount of time, in milliseconds, to wait before the second attempt. The time is doubled after every attempt, up to `max_backoff`.",
+		},
+		"max_backoff": {
+			Type:        schema.TypeInt,
+			Optional:    true,
+			Default:     30000,
+			Description: "The maximum amount of time, in milliseconds, to wait between attempts.",
+		},
+		"timeout": {
+			Type:        schema.TypeInt,
+			Optional:    true,
+			Description: "The maximum amount of time, in milliseconds, to spend on all attempts. Timeout of zero means no timeout.",
+		},
+	},
+}
+
+func connectionSchema() map[string]*schema.Schema {
+	s := hostSchema()
+	s["host"].ForceNew = true
+	s["port"].ForceNew = true
+	s["sudo"] = &schema.Schema{
+		Type:        schema.TypeBool,
+		Optional:    true,
+		Default:     false,
+		Description: "Use sudo to gain access to file.",
+	}
+	s["sftp_server_path"] = &schema.Schema{
+		Type:        schema.TypeString,
+		Optional:    true,
+		Description: "Path to the SFTP server on the remote host, such as `/usr/lib/openssh/sftp-server` or `/usr/libexec/openssh/sftp-server`. When set with `sudo` or `become`, the SFTP server is started as the user to become and files are accessed over SFTP instead of shell commands.",
+	}
+	s["become"] = &schema.Schema{
+		Type:        schema.TypeList,
+		Optional:    true,
+		MaxItems:    1,
+		Elem:        becomeSchemaResource,
+		Description: "How to gain access to files and run commands as another user. Implies `sudo`, which uses plain `sudo` unless `become` is set.",
+	}
+	s["retry"] = &schema.Schema{
+		Type:        schema.TypeList,
+		Optional:    true,
+		MaxItems:    1,
+		Elem:        retrySchemaResource,
+		Description: "Retries connecting to the remote host and its jump hosts, such as when they are still booting, and opening sessions refused by the remote host, such as when `MaxSessions` of its SSH server is exceeded. Nothing is retried unless `retry` is set.",
+	}
+	s["ssh_config"] = &schema.Schema{
+		Type:        schema.TypeString,
+		Optional:    true,
+		Description: "Path to an OpenSSH client configuration file, such as `~/.ssh/config`, in which `host` is looked up like ssh does. `HostName` replaces `host`, and `User`, `Port` and `ProxyJump` are used unless `user`, a `port` other than 22 or `proxy_jump` are set. Keys in `IdentityFile` are used along with the other credentials, and `IdentitiesOnly` limits the keys of the SSH agent to them. Jump hosts from `ProxyJump` are also looked up in the file, and are authenticated with the credentials of the remote host. `Match` blocks and `Include` directives are not supported.",
+	}
+	s["proxy_jump"] = &schema.Schema{
+		Type:        schema.TypeList,
+		Optional:    true,
+		Elem:        proxyJumpSchemaResource,
+		Description: "Jump hosts used to reach the remote host, in the order they are connected to. Connections to the jump hosts are shared between remote hosts behind the same jump hosts.",
+	}
+	return s
+}
+
+// proxyJumpSchema requires the user, as jump hosts are not looked up in
+// `ssh_config`.
+func proxyJumpSchema() map[string]*schema.Schema {
+	s := hostSchema()
+	s["user"].Optional = false
+	s["user"].Required = true
+	s["user"].Description = "The user on the remote host."
+	return s
+}
+
+// hostSchema contains the attributes used to connect and authenticate to a
+// host, shared by the remote host and its jump hosts.
+func hostSchema() map[string]*schema.Schema {
+	return map[string]*schema.Schema{
 		"host": {
 			Type:        schema.TypeString,
 			Required:    true,
-			ForceNew:    true,
 			Description: "The remote host.",
 		},
 		"port": {
 			Type:        schema.TypeInt,
 			Optional:    true,
 			Default:     22,
-			ForceNew:    true,
 			Description: "The ssh port on the remote host.",
 		},
 		"timeout": {
@@ -34,14 +161,8 @@ var connectionSchemaResource = &schema.Resource{
 		},
 		"user": {
 			Type:        schema.TypeString,
-			Required:    true,
-			Description: "The user on the remote host.",
-		},
-		"sudo": {
-			Type:        schema.TypeBool,
 			Optional:    true,
-			Default:     false,
-			Description: "Use sudo to gain access to file.",
+			Description: "The user on the remote host. Required unless found in `ssh_config`.",
 		},
 		"agent": {
 			Type:        schema.TypeBool,
@@ -49,12 +170,29 @@ var connectionSchemaResource = &schema.Resource{
 			Default:     false,
 			Description: "Use a local SSH agent to login to the remote host.",
 		},
+		"agent_socket": {
+			Type:        schema.TypeString,
+			Optional:    true,
+			Description: "The local path to the socket of the SSH agent, such as the agent of 1Password or gpg-agent. Defaults to `SSH_AUTH_SOCK`. Setting it implies `agent`.",
+		},
+		"agent_identity": {
+			Type:        schema.TypeString,
+			Optional:    true,
+			Description: "The only key of the SSH agent to login with, along with its certificates, instead of trying every key. Either the fingerprint of the key (`SHA256:...` or `MD5:...`) or its comment, as listed by `ssh-add -l`.",
+		},
 		"password": {
 			Type:        schema.TypeString,
 			Optional:    true,
 			Sensitive:   true,
 			Description: "The pasword for the user on the remote host.",
 		},
+		"keyboard_interactive_answers": {
+			Type:        schema.TypeMap,
+			Optional:    true,
+			Sensitive:   true,
+			Elem:        &schema.Schema{Type: schema.TypeString},
+			Description: "Answers to `keyboard-interactive` prompts of the remote host, such as a static one-time password, keyed by text contained in the prompt, ignoring case. Prompts containing `password` are otherwise answered with `password`.",
+		},
 		"private_key": {
 			Type:        schema.TypeString,
 			Optional:    true,
@@ -77,103 +215,857 @@ var connectionSchemaResource = &schema.Resource{
 			Optional:    true,
 			Description: "The name of the local environment variable containing the private key used to login to the remote host.",
 		},
-	},
+		"certificate": {
+			Type:        schema.TypeString,
+			Optional:    true,
+			Description: "The SSH certificate used to login with the private key it is signed for, in authorized keys format (`ssh-ed25519-cert-v01@openssh.com AAAA...`). The key may also be held by the SSH agent. Certificates held by the SSH agent are used without setting `certificate`.",
+		},
+		"certificate_path": {
+			Type:        schema.TypeString,
+			Optional:    true,
+			Description: "The local path to the SSH certificate used to login with the private key it is signed for.",
+		},
+		"host_ca_public_key": {
+			Type:        schema.TypeString,
+			Optional:    true,
+			Description: "The public key of the certificate authority signing host certificates, in authorized keys format. Hosts presenting a valid certificate signed by it are accepted. Other host keys are verified with `host_key` or the known hosts file when set, and are otherwise refused.",
+		},
+		"host_key": {
+			Type:        schema.TypeString,
+			Optional:    true,
+			Description: "The public key of the remote host, in authorized keys format (`ssh-ed25519 AAAA...`). The connection is refused if the remote host presents another key.",
+		},
+		"known_hosts_path": {
+			Type:        schema.TypeString,
+			Optional:    true,
+			Description: "The local path to the known hosts file used to verify the public key of the remote host. Defaults to `~/.ssh/known_hosts` when `strict_host_key_checking` is `yes` or `accept-new`.",
+		},
+		"host_key_algorithms": {
+			Type:        schema.TypeList,
+			Optional:    true,
+			Elem:        &schema.Schema{Type: schema.TypeString},
+			Description: "The host key algorithms accepted from the remote host, in order of preference. Defaults to the algorithms of the keys in `host_key` or the known hosts file.",
+		},
+		"strict_host_key_checking": {
+			Type:             schema.TypeString,
+			Optional:         true,
+			ValidateDiagFunc: validation.ToDiagFunc(validation.StringInSlice([]string{"yes", "no", "accept-new"}, false)),
+			Description:      "How to verify the public key of the remote host against the known hosts file. `yes` refuses unknown hosts, `accept-new` adds unknown hosts to the known hosts file and `no` skips the verification. Defaults to `yes` when `known_hosts_path` is set, otherwise `no`. A `host_key` is always verified.",
+		},
+	}
+}
+
+// hostConnection holds the attributes used to connect and authenticate to a
+// host, shared by the remote host and its jump hosts. Attributes that are not
+// set have their zero value.
+type hostConnection struct {
+	host string
+	port int
+	// Milliseconds, where zero means no timeout.
+	timeout                    int
+	user                       string
+	agent                      bool
+	agentSocket                string
+	agentIdentity              string
+	password                   string
+	keyboardInteractiveAnswers map[string]string
+	privateKey                 string
+	privateKeyPass             string
+	privateKeyPath             string
+	privateKeyEnvVar           string
+	certificate                string
+	certificatePath            string
+	hostCAPublicKey            string
+	hostKey                    string
+	knownHostsPath             string
+	hostKeyAlgorithms          []string
+	strictHostKeyChecking      string
+}
+
+// connection holds the attributes of a `conn` block, which is read from both
+// the SDK and the framework.
+type connection struct {
+	hostConnection
+	// Whether files are accessed and commands are run as another user, with
+	// `sudo` or `become`.
+	sudo bool
+	// How to become another user, which defaults to plain `sudo`.
+	become         *become
+	sftpServerPath string
+	// Nil when nothing is retried.
+	retry      *retryPolicy
+	sshConfig  string
+	proxyJumps []hostConnection
 }
 
-func ConnectionFromResourceData(ctx context.Context, d *schema.ResourceData) (string, *ssh.ClientConfig, error) {
+// connectionFromResourceData returns the `conn` block of d.
+func connectionFromResourceData(d *schema.ResourceData) (*connection, error) {
 	if _, ok := d.GetOk("conn"); !ok {
-		return "", nil, fmt.Errorf("resouce does not have a connection configured")
+		return nil, fmt.Errorf("resouce does not have a connection configured")
 	}
 
-	host, err := Get[string](d, "conn.0.host")
+	host, err := hostConnectionFromResourceData(d, "conn.0")
 	if err != nil {
-		return "", nil, err
+		return nil, err
 	}
+	c := &connection{hostConnection: host}
 
-	port, err := Get[int](d, "conn.0.port")
+	sudo, _, err := GetOk[bool](d, "conn.0.sudo")
 	if err != nil {
-		return "", nil, err
+		return nil, err
 	}
 
-	user, err := Get[string](d, "conn.0.user")
+	_, becomeOk, err := GetOk[[]interface{}](d, "conn.0.become")
 	if err != nil {
-		return "", nil, err
+		return nil, err
 	}
+	c.sudo = sudo || becomeOk
 
-	clientConfig := ssh.ClientConfig{
-		User:            user,
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	c.become, err = becomeFromResourceData(d)
+	if err != nil {
+		return nil, err
+	}
+
+	c.sftpServerPath, _, err = GetOk[string](d, "conn.0.sftp_server_path")
+	if err != nil {
+		return nil, err
+	}
+
+	c.retry, err = retryFromResourceData(d)
+	if err != nil {
+		return nil, err
 	}
 
-	if password, ok, err := GetOk[string](d, "conn.0.password"); ok {
+	c.sshConfig, _, err = GetOk[string](d, "conn.0.ssh_config")
+	if err != nil {
+		return nil, err
+	}
+
+	proxyJumps, _, err := GetOk[[]interface{}](d, "conn.0.proxy_jump")
+	if err != nil {
+		return nil, err
+	}
+	for i := range proxyJumps {
+		jump, err := hostConnectionFromResourceData(d, fmt.Sprintf("conn.0.proxy_jump.%d", i))
 		if err != nil {
-			return "", nil, err
+			return nil, err
 		}
+		c.proxyJumps = append(c.proxyJumps, jump)
+	}
+
+	return c, nil
+}
+
+// hostConnectionFromResourceData returns the host at prefix.
+func hostConnectionFromResourceData(d *schema.ResourceData, prefix string) (hostConnection, error) {
+	var h hostConnection
+	var err error
+
+	h.host, err = Get[string](d, prefix+".host")
+	if err != nil {
+		return h, err
+	}
+
+	h.port, err = Get[int](d, prefix+".port")
+	if err != nil {
+		return h, err
+	}
+
+	h.timeout, _, err = GetOk[int](d, prefix+".timeout")
+	if err != nil {
+		return h, err
+	}
 
-		clientConfig.Auth = append(clientConfig.Auth, ssh.Password(password))
+	h.user, _, err = GetOk[string](d, prefix+".user")
+	if err != nil {
+		return h, err
+	}
+
+	// Should ideally use Get as it has a default and should always exist.
+	// However GetOk as Terraform returns false for exists when value equals
+	// zero value (which the default for agent does). Could maybe use
+	// GetOkExists, but discouraged.
+	h.agent, _, err = GetOk[bool](d, prefix+".agent")
+	if err != nil {
+		return h, err
+	}
+
+	h.agentSocket, _, err = GetOk[string](d, prefix+".agent_socket")
+	if err != nil {
+		return h, err
+	}
+
+	h.agentIdentity, _, err = GetOk[string](d, prefix+".agent_identity")
+	if err != nil {
+		return h, err
+	}
+
+	h.password, _, err = GetOk[string](d, prefix+".password")
+	if err != nil {
+		return h, err
+	}
+
+	answers, _, err := GetOk[map[string]interface{}](d, prefix+".keyboard_interactive_answers")
+	if err != nil {
+		return h, err
+	}
+	for prompt, answer := range answers {
+		if h.keyboardInteractiveAnswers == nil {
+			h.keyboardInteractiveAnswers = map[string]string{}
+		}
+		h.keyboardInteractiveAnswers[prompt] = answer.(string)
+	}
+
+	h.privateKey, _, err = GetOk[string](d, prefix+".private_key")
+	if err != nil {
+		return h, err
 	}
 
-	if privateKey, ok, err := GetOk[string](d, "conn.0.private_key"); ok {
+	h.privateKeyPass, _, err = GetOk[string](d, prefix+".private_key_pass")
+	if err != nil {
+		return h, err
+	}
+
+	h.privateKeyPath, _, err = GetOk[string](d, prefix+".private_key_path")
+	if err != nil {
+		return h, err
+	}
+
+	h.privateKeyEnvVar, _, err = GetOk[string](d, prefix+".private_key_env_var")
+	if err != nil {
+		return h, err
+	}
+
+	h.certificate, _, err = GetOk[string](d, prefix+".certificate")
+	if err != nil {
+		return h, err
+	}
+
+	h.certificatePath, _, err = GetOk[string](d, prefix+".certificate_path")
+	if err != nil {
+		return h, err
+	}
+
+	h.hostCAPublicKey, _, err = GetOk[string](d, prefix+".host_ca_public_key")
+	if err != nil {
+		return h, err
+	}
+
+	h.hostKey, _, err = GetOk[string](d, prefix+".host_key")
+	if err != nil {
+		return h, err
+	}
+
+	h.knownHostsPath, _, err = GetOk[string](d, prefix+".known_hosts_path")
+	if err != nil {
+		return h, err
+	}
+
+	algorithms, _, err := GetOk[[]interface{}](d, prefix+".host_key_algorithms")
+	if err != nil {
+		return h, err
+	}
+	for _, algorithm := range algorithms {
+		h.hostKeyAlgorithms = append(h.hostKeyAlgorithms, algorithm.(string))
+	}
+
+	h.strictHostKeyChecking, _, err = GetOk[string](d, prefix+".strict_host_key_checking")
+	if err != nil {
+		return h, err
+	}
+
+	return h, nil
+}
+
+// becomeFromResourceData returns how to become another user, which defaults
+// to plain `sudo`.
+func becomeFromResourceData(d *schema.ResourceData) (*become, error) {
+	if _, ok := d.GetOk("conn.0.become"); !ok {
+		return &become{method: "sudo"}, nil
+	}
+
+	method, err := Get[string](d, "conn.0.become.0.method")
+	if err != nil {
+		return nil, err
+	}
+
+	user, _, err := GetOk[string](d, "conn.0.become.0.user")
+	if err != nil {
+		return nil, err
+	}
+
+	password, _, err := GetOk[string](d, "conn.0.become.0.password")
+	if err != nil {
+		return nil, err
+	}
+
+	flags, _, err := GetOk[[]interface{}](d, "conn.0.become.0.flags")
+	if err != nil {
+		return nil, err
+	}
+
+	b := &become{method: method, user: user, password: password}
+	for _, flag := range flags {
+		b.flags = append(b.flags, flag.(string))
+	}
+	return b, b.validate()
+}
+
+// retryFromResourceData returns how to retry connecting and opening sessions,
+// which is nil when nothing is retried.
+func retryFromResourceData(d *schema.ResourceData) (*retryPolicy, error) {
+	if _, ok := d.GetOk("conn.0.retry"); !ok {
+		return nil, nil
+	}
+
+	maxAttempts, err := Get[int](d, "conn.0.retry.0.max_attempts")
+	if err != nil {
+		return nil, err
+	}
+
+	initialBackoff, err := Get[int](d, "conn.0.retry.0.initial_backoff")
+	if err != nil {
+		return nil, err
+	}
+
+	maxBackoff, err := Get[int](d, "conn.0.retry.0.max_backoff")
+	if err != nil {
+		return nil, err
+	}
+
+	timeout, _, err := GetOk[int](d, "conn.0.retry.0.timeout")
+	if err != nil {
+		return nil, err
+	}
+
+	return &retryPolicy{
+		maxAttempts:    maxAttempts,
+		initialBackoff: time.Duration(initialBackoff) * time.Millisecond,
+		maxBackoff:     time.Duration(maxBackoff) * time.Millisecond,
+		timeout:        time.Duration(timeout) * time.Millisecond,
+	}, nil
+}
+
+// hash identifies the connection in the pool of remote clients.
+func (c *connection) hash() string {
+	elements := []string{}
+	for _, jump := range c.proxyJumps {
+		elements = append(elements, jump.hash())
+	}
+	elements = append(elements, c.hostConnection.hash())
+
+	return fmt.Sprintf("%s::%s::%s::%s", strings.Join(elements, "->"), becomeHash(c.become), c.sftpServerPath, c.sshConfig)
+}
+
+func becomeHash(b *become) string {
+	elements := append([]string{b.method, b.user, b.password}, b.flags...)
+	return strings.Join(elements, "::")
+}
+
+func (h *hostConnection) hash() string {
+	elements := []string{
+		h.host,
+		h.user,
+		strconv.Itoa(h.port),
+		h.hostKey,
+		h.knownHostsPath,
+		h.strictHostKeyChecking,
+		fmt.Sprint(h.hostKeyAlgorithms),
+		h.hostCAPublicKey,
+		h.credentialHash(),
+	}
+	return strings.Join(elements, "::")
+}
+
+// credentialHash identifies the credentials used to login to the host, which
+// are also used to login to its jump hosts in `ssh_config`.
+func (h *hostConnection) credentialHash() string {
+	answers := []string{}
+	for prompt, answer := range h.keyboardInteractiveAnswers {
+		answers = append(answers, fmt.Sprintf("%s=%s", prompt, answer))
+	}
+	slices.Sort(answers)
+
+	elements := []string{
+		h.password,
+		h.privateKey,
+		h.privateKeyPath,
+		strconv.FormatBool(h.agent),
+		h.certificate,
+		h.certificatePath,
+		h.agentSocket,
+		h.agentIdentity,
+		strings.Join(answers, ","),
+	}
+	return strings.Join(elements, "::")
+}
+
+// remoteClientConfig returns the address and client config of the remote
+// host, along with the SSH agent used by the client config, which must be
+// closed when no longer needed unless it is nil.
+func (c *connection) remoteClientConfig(ctx context.Context) (string, *ssh.ClientConfig, *sshAgent, error) {
+	hostConfig, _, err := c.lookupSSHConfig()
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	return c.clientConfig(ctx, hostConfig)
+}
+
+// lookupSSHConfig returns the options of `ssh_config` that apply to the
+// remote host, along with the file to look up its jump hosts in. Both are nil
+// when `ssh_config` is not set.
+func (c *connection) lookupSSHConfig() (*sshHostConfig, *sshConfig, error) {
+	if c.sshConfig == "" {
+		return nil, nil, nil
+	}
+
+	config, err := readSSHConfig(c.sshConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hostConfig, err := config.lookup(c.host)
+	if err != nil {
+		return nil, nil, err
+	}
+	return hostConfig, config, nil
+}
+
+type jumpHost struct {
+	id           string
+	address      string
+	clientConfig *ssh.ClientConfig
+	// The SSH agent used to login to the jump host, if any, which is closed
+	// once connected.
+	agent *sshAgent
+}
+
+// jumpHosts returns the jump hosts used to reach the remote host, in the
+// order they are connected to.
+func (c *connection) jumpHosts(ctx context.Context) ([]jumpHost, error) {
+	if len(c.proxyJumps) == 0 {
+		return c.sshConfigJumpHosts(ctx)
+	}
+
+	jumpHosts := []jumpHost{}
+	for i, jump := range c.proxyJumps {
+		address, clientConfig, keyAgent, err := jump.clientConfig(ctx, nil)
+		if err != nil {
+			return nil, fmt.Errorf("jump host %d: %s", i, err.Error())
+		}
+
+		jumpHosts = append(jumpHosts, jumpHost{
+			id:           jump.hash(),
+			address:      address,
+			clientConfig: clientConfig,
+			agent:        keyAgent,
+		})
+	}
+
+	return jumpHosts, nil
+}
+
+// sshConfigJumpHosts returns the jump hosts in `ProxyJump` of the remote host
+// in `ssh_config`, which are authenticated with the credentials of the remote
+// host.
+func (c *connection) sshConfigJumpHosts(ctx context.Context) ([]jumpHost, error) {
+	hostConfig, config, err := c.lookupSSHConfig()
+	if err != nil || hostConfig == nil {
+		return []jumpHost{}, err
+	}
+
+	jumpHosts := []jumpHost{}
+	for _, spec := range hostConfig.proxyJump {
+		jumpUser, host, port, err := parseJumpSpec(spec)
 		if err != nil {
-			return "", nil, err
+			return nil, err
 		}
 
-		signer, err := parsePrivateKey(d, privateKey)
+		jumpConfig, err := config.lookup(host)
 		if err != nil {
-			return "", nil, fmt.Errorf("couldn't create a ssh client config from private key: %s", err.Error())
+			return nil, err
+		}
+		if jumpUser != "" {
+			jumpConfig.user = jumpUser
+		}
+		if port != 0 {
+			jumpConfig.port = port
 		}
-		clientConfig.Auth = append(clientConfig.Auth, ssh.PublicKeys(signer))
+		if jumpConfig.user == "" {
+			// Like ssh, connect as the local user by default.
+			localUser, err := user.Current()
+			if err != nil {
+				return nil, fmt.Errorf("couldn't find user for jump host %s: %s", host, err.Error())
+			}
+			jumpConfig.user = localUser.Username
+		}
+		if jumpConfig.port == 0 {
+			jumpConfig.port = 22
+		}
+
+		address := net.JoinHostPort(jumpConfig.hostName, strconv.Itoa(jumpConfig.port))
+		clientConfig := ssh.ClientConfig{
+			User:    jumpConfig.user,
+			Timeout: time.Duration(c.timeout) * time.Millisecond,
+		}
+
+		if err := c.setHostKeyCallback(address, &clientConfig, false); err != nil {
+			return nil, err
+		}
+
+		authMethods, keyAgent, err := c.authMethods(jumpConfig)
+		if err != nil {
+			return nil, fmt.Errorf("jump host %s: %s", host, err.Error())
+		}
+		clientConfig.Auth = authMethods
+
+		jumpHosts = append(jumpHosts, jumpHost{
+			id:           sshConfigJumpID(jumpConfig, address, c.hostConnection.credentialHash()),
+			address:      address,
+			clientConfig: &clientConfig,
+			agent:        keyAgent,
+		})
+	}
+
+	return jumpHosts, nil
+}
+
+// sshConfigJumpID identifies a jump host in `ssh_config` in the pool of jump
+// clients by its address and the credentials used to login to it, so that
+// remote hosts with different credentials do not share connections to it.
+func sshConfigJumpID(jumpConfig *sshHostConfig, address string, credentials string) string {
+	elements := []string{
+		fmt.Sprintf("%s@%s", jumpConfig.user, address),
+		credentials,
+		strings.Join(jumpConfig.identityFiles, ","),
+		strconv.FormatBool(jumpConfig.identitiesOnly),
 	}
+	return strings.Join(elements, "::")
+}
 
-	if privateKeyPath, ok, err := GetOk[string](d, "conn.0.private_key_path"); ok {
+// clientConfig returns the address and client config of the host, where
+// unset attributes are taken from hostConfig unless it is nil, along with the
+// SSH agent used by the client config, if any.
+func (h *hostConnection) clientConfig(ctx context.Context, hostConfig *sshHostConfig) (string, *ssh.ClientConfig, *sshAgent, error) {
+	host, port, user := h.host, h.port, h.user
+	if hostConfig != nil {
+		host = hostConfig.hostName
+		if user == "" {
+			user = hostConfig.user
+		}
+		// The port defaults to 22, so the port in the ssh config is used
+		// unless another port is set.
+		if port == 22 && hostConfig.port != 0 {
+			port = hostConfig.port
+		}
+	}
+	if user == "" {
+		return "", nil, nil, fmt.Errorf("no user is set for host %s", host)
+	}
+
+	address := net.JoinHostPort(host, strconv.Itoa(port))
+	clientConfig := ssh.ClientConfig{
+		User:    user,
+		Timeout: time.Duration(h.timeout) * time.Millisecond,
+	}
+
+	if err := h.setHostKeyCallback(address, &clientConfig, true); err != nil {
+		return "", nil, nil, err
+	}
+
+	authMethods, keyAgent, err := h.authMethods(hostConfig)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	clientConfig.Auth = authMethods
+
+	return address, &clientConfig, keyAgent, nil
+}
+
+// authMethods returns the methods used to authenticate to the host,
+// including the identity files in hostConfig unless it is nil, along with the
+// SSH agent that must be closed once connected, which is nil when not used.
+// All keys are offered by a single public key method, as the client only
+// tries the first method of each type.
+func (h *hostConnection) authMethods(hostConfig *sshHostConfig) ([]ssh.AuthMethod, *sshAgent, error) {
+	var authMethods []ssh.AuthMethod
+
+	if h.password != "" {
+		authMethods = append(authMethods, ssh.Password(h.password))
+	}
+
+	var signers []ssh.Signer
+
+	if h.privateKey != "" {
+		signer, err := parsePrivateKey(h.privateKey, h.privateKeyPass)
 		if err != nil {
-			return "", nil, err
+			return nil, nil, fmt.Errorf("couldn't create a ssh client config from private key: %s", err.Error())
 		}
+		signers = append(signers, signer)
+	}
 
-		content, err := os.ReadFile(privateKeyPath)
+	if h.privateKeyPath != "" {
+		content, err := os.ReadFile(h.privateKeyPath)
+		if err != nil {
+			return nil, nil, fmt.Errorf("couldn't read private key: %s", err.Error())
+		}
+		signer, err := parsePrivateKey(string(content), h.privateKeyPass)
 		if err != nil {
-			return "", nil, fmt.Errorf("couldn't read private key: %s", err.Error())
+			return nil, nil, fmt.Errorf("couldn't create a ssh client config from private key file: %s", err.Error())
 		}
-		signer, err := parsePrivateKey(d, string(content))
+		signers = append(signers, signer)
+	}
+
+	if h.privateKeyEnvVar != "" {
+		content := os.Getenv(h.privateKeyEnvVar)
+		signer, err := parsePrivateKey(content, h.privateKeyPass)
 		if err != nil {
-			return "", nil, fmt.Errorf("couldn't create a ssh client config from private key file: %s", err.Error())
+			return nil, nil, fmt.Errorf("couldn't create a ssh client config from private key env var: %s", err.Error())
 		}
-		clientConfig.Auth = append(clientConfig.Auth, ssh.PublicKeys(signer))
+		signers = append(signers, signer)
 	}
 
-	if privateKeyEnvVar, ok, err := GetOk[string](d, "conn.0.private_key_env_var"); ok {
+	keyAgent, err := h.keyAgent()
+	if err != nil {
+		return nil, nil, err
+	}
+	enableAgent := keyAgent != nil
+
+	var identityKeys []ssh.PublicKey
+	if hostConfig != nil {
+		identitySigners, keys, err := h.identityFileSigners(hostConfig.identityFiles, enableAgent)
 		if err != nil {
-			return "", nil, err
+			return nil, nil, err
 		}
+		signers = append(signers, identitySigners...)
+		identityKeys = keys
+	}
 
-		content := os.Getenv(privateKeyEnvVar)
-		signer, err := parsePrivateKey(d, content)
+	certificate, err := h.readCertificate()
+	if err != nil {
+		return nil, nil, err
+	}
+	if certificate != nil {
+		signers, err = certSigners(signers, certificate)
 		if err != nil {
-			return "", nil, fmt.Errorf("couldn't create a ssh client config from private key env var: %s", err.Error())
+			return nil, nil, err
+		}
+		if !enableAgent && !slices.ContainsFunc(signers, isCertSigner) {
+			return nil, nil, fmt.Errorf("certificate is not signed for any of the private keys")
+		}
+	}
+
+	agentSigners := func() ([]ssh.Signer, error) { return nil, nil }
+	if enableAgent {
+		agentSigners = keyAgent.Signers
+		if hostConfig != nil && hostConfig.identitiesOnly {
+			agentSigners = identitySigners(agentSigners, identityKeys)
+		}
+		if certificate != nil {
+			agentSigners = agentCertSigners(agentSigners, certificate)
+		}
+	}
+
+	if len(signers) > 0 || enableAgent {
+		authMethods = append(authMethods, ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+			fromAgent, err := agentSigners()
+			if err != nil {
+				return nil, err
+			}
+			return append(slices.Clone(signers), fromAgent...), nil
+		}))
+	}
+
+	if h.password != "" || len(h.keyboardInteractiveAnswers) > 0 {
+		authMethods = append(authMethods, ssh.KeyboardInteractive(keyboardInteractiveChallenge(h.password, h.keyboardInteractiveAnswers)))
+	}
+
+	return authMethods, keyAgent, nil
+}
+
+// keyboardInteractiveChallenge answers the prompts of keyboard-interactive
+// authentication with the first of answers keyed by text contained in the
+// prompt, or with password when the prompt asks for a password.
+func keyboardInteractiveChallenge(password string, answers map[string]string) ssh.KeyboardInteractiveChallenge {
+	keys := make([]string, 0, len(answers))
+	for key := range answers {
+		keys = append(keys, key)
+	}
+	// Prefer the most specific answer when several keys match a prompt.
+	slices.SortFunc(keys, func(a, b string) int {
+		return cmp.Or(cmp.Compare(len(b), len(a)), cmp.Compare(a, b))
+	})
+
+	return func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+		replies := make([]string, 0, len(questions))
+	prompts:
+		for _, question := range questions {
+			prompt := strings.ToLower(question)
+			for _, key := range keys {
+				if strings.Contains(prompt, strings.ToLower(key)) {
+					replies = append(replies, answers[key])
+					continue prompts
+				}
+			}
+			if password != "" && strings.Contains(prompt, "password") {
+				replies = append(replies, password)
+				continue
+			}
+			return nil, fmt.Errorf("no answer to keyboard-interactive prompt %q", question)
 		}
-		clientConfig.Auth = append(clientConfig.Auth, ssh.PublicKeys(signer))
+		return replies, nil
 	}
+}
+
+// readCertificate returns the certificate in `certificate` or
+// `certificate_path`, which is nil when neither is set.
+func (h *hostConnection) readCertificate() (*ssh.Certificate, error) {
+	content := h.certificate
+	if content == "" {
+		if h.certificatePath == "" {
+			return nil, nil
+		}
 
-	// Don't check ok as terraform struggles with zero values.
-	if enableAgent, _, err := GetOk[bool](d, "conn.0.agent"); enableAgent {
+		file, err := os.ReadFile(h.certificatePath)
 		if err != nil {
-			return "", nil, err
+			return nil, fmt.Errorf("couldn't read certificate: %s", err.Error())
 		}
+		content = string(file)
+	}
 
-		connection, err := net.Dial("unix", os.Getenv("SSH_AUTH_SOCK"))
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(content))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse certificate: %s", err.Error())
+	}
+	certificate, ok := key.(*ssh.Certificate)
+	if !ok {
+		return nil, fmt.Errorf("certificate is a plain %s public key", key.Type())
+	}
+	return certificate, nil
+}
+
+// certSigners wraps the signers that certificate is signed for, such that
+// they present the certificate instead of their public key.
+func certSigners(signers []ssh.Signer, certificate *ssh.Certificate) ([]ssh.Signer, error) {
+	wrapped := make([]ssh.Signer, 0, len(signers))
+	for _, signer := range signers {
+		if !isCertSigner(signer) && bytes.Equal(signer.PublicKey().Marshal(), certificate.Key.Marshal()) {
+			certSigner, err := ssh.NewCertSigner(certificate, signer)
+			if err != nil {
+				return nil, fmt.Errorf("couldn't use certificate: %s", err.Error())
+			}
+			signer = certSigner
+		}
+		wrapped = append(wrapped, signer)
+	}
+	return wrapped, nil
+}
+
+// agentCertSigners wraps the keys in the SSH agent that certificate is signed
+// for, while keeping the certificates held by the agent itself.
+func agentCertSigners(signers func() ([]ssh.Signer, error), certificate *ssh.Certificate) func() ([]ssh.Signer, error) {
+	return func() ([]ssh.Signer, error) {
+		all, err := signers()
 		if err != nil {
-			return "", nil, fmt.Errorf("couldn't connect to SSH agent: %s", err.Error())
+			return nil, err
+		}
+		return certSigners(all, certificate)
+	}
+}
+
+func isCertSigner(signer ssh.Signer) bool {
+	_, ok := signer.PublicKey().(*ssh.Certificate)
+	return ok
+}
+
+// keyAgent returns the SSH agent used to login to the host, which is nil when
+// no agent is used.
+func (h *hostConnection) keyAgent() (*sshAgent, error) {
+	if !h.agent && h.agentSocket == "" {
+		return nil, nil
+	}
+
+	socket := h.agentSocket
+	if socket == "" {
+		socket = os.Getenv("SSH_AUTH_SOCK")
+		if socket == "" {
+			return nil, fmt.Errorf("couldn't connect to SSH agent: SSH_AUTH_SOCK is not set")
 		}
-		clientConfig.Auth = append(clientConfig.Auth, ssh.PublicKeysCallback(agent.NewClient(connection).Signers))
+	}
+	socket, err := expandHome(socket)
+	if err != nil {
+		return nil, err
 	}
 
-	if timeout, ok, err := GetOk[int](d, "conn.0.timeout"); ok {
+	return &sshAgent{socket: socket, identity: h.agentIdentity}, nil
+}
+
+// identityFileSigners returns signers for the identity files that exist,
+// along with the public keys of all of them. Encrypted identity files without
+// a passphrase are expected to be in the SSH agent when it is used, so only
+// their public keys are read from the `.pub` file next to them.
+func (h *hostConnection) identityFileSigners(identityFiles []string, enableAgent bool) ([]ssh.Signer, []ssh.PublicKey, error) {
+	var signers []ssh.Signer
+	var keys []ssh.PublicKey
+	for _, identityFile := range identityFiles {
+		content, err := os.ReadFile(identityFile)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
 		if err != nil {
-			return "", nil, err
+			return nil, nil, fmt.Errorf("couldn't read identity file: %s", err.Error())
 		}
 
-		clientConfig.Timeout = time.Duration(timeout) * time.Millisecond
+		if _, err := ssh.ParseRawPrivateKey(content); enableAgent && h.privateKeyPass == "" {
+			var passphraseErr *ssh.PassphraseMissingError
+			if errors.As(err, &passphraseErr) {
+				if key, err := readPublicKey(identityFile + ".pub"); err == nil {
+					keys = append(keys, key)
+				}
+				continue
+			}
+		}
+
+		signer, err := parsePrivateKey(string(content), h.privateKeyPass)
+		if err != nil {
+			return nil, nil, fmt.Errorf("couldn't create a ssh client config from identity file %s: %s", identityFile, err.Error())
+		}
+		signers = append(signers, signer)
+		keys = append(keys, signer.PublicKey())
 	}
 
-	return fmt.Sprintf("%s:%d", host, port), &clientConfig, nil
+	return signers, keys, nil
+}
+
+func readPublicKey(path string) (ssh.PublicKey, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, _, _, _, err := ssh.ParseAuthorizedKey(content)
+	return key, err
+}
+
+// identitySigners returns the signers of signers that have one of keys, or
+// hold a certificate for one of them.
+func identitySigners(signers func() ([]ssh.Signer, error), keys []ssh.PublicKey) func() ([]ssh.Signer, error) {
+	return func() ([]ssh.Signer, error) {
+		all, err := signers()
+		if err != nil {
+			return nil, err
+		}
+
+		var filtered []ssh.Signer
+		for _, signer := range all {
+			publicKey := underlyingKey(signer.PublicKey())
+			for _, key := range keys {
+				if bytes.Equal(publicKey.Marshal(), key.Marshal()) {
+					filtered = append(filtered, signer)
+					break
+				}
+			}
+		}
+		return filtered, nil
+	}
 }
@@ -0,0 +1,413 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-framework-validators/int64validator"
+	"github.com/hashicorp/terraform-plugin-framework-validators/listvalidator"
+	"github.com/hashicorp/terraform-plugin-framework-validators/stringvalidator"
+	dsschema "github.com/hashicorp/terraform-plugin-framework/datasource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/booldefault"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/boolplanmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/int64default"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/int64planmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/planmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/stringdefault"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/stringplanmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/schema/validator"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	sdkschema "github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// connectionBlock returns the `conn` block of resources built on the
+// framework, converted from the block of the SDK resources like
+// providerSchema. It is a block rather than a nested attribute, to keep the
+// configurations and state written with the SDK valid.
+func connectionBlock(description string) schema.ListNestedBlock {
+	block := resourceBlock(&sdkschema.Schema{
+		Type:     sdkschema.TypeList,
+		MaxItems: 1,
+		Elem:     connectionSchemaResource,
+	})
+	block.MarkdownDescription = description
+	return block
+}
+
+// connectionStringValidators and connectionInt64Validators replace the
+// validation functions of the SDK attributes with the same name in the
+// `conn` block, which cannot be converted.
+var (
+	connectionStringValidators = map[string][]validator.String{
+		"method":                   {stringvalidator.OneOf("sudo", "doas", "su", "pbrun")},
+		"strict_host_key_checking": {stringvalidator.OneOf("yes", "no", "accept-new")},
+	}
+	connectionInt64Validators = map[string][]validator.Int64{
+		"max_attempts": {int64validator.AtLeast(1)},
+	}
+)
+
+// resourceBlock converts a block of the `conn` block of SDK resources. Blocks
+// with MaxItems are validated to have at most that many items.
+func resourceBlock(s *sdkschema.Schema) schema.ListNestedBlock {
+	attributes := map[string]schema.Attribute{}
+	blocks := map[string]schema.Block{}
+
+	for name, attribute := range s.Elem.(*sdkschema.Resource).Schema {
+		if _, ok := attribute.Elem.(*sdkschema.Resource); ok {
+			blocks[name] = resourceBlock(attribute)
+			continue
+		}
+		attributes[name] = resourceAttribute(name, attribute)
+	}
+
+	var validators []validator.List
+	if s.MaxItems > 0 {
+		validators = append(validators, listvalidator.SizeAtMost(s.MaxItems))
+	}
+
+	return schema.ListNestedBlock{
+		MarkdownDescription: sdkschema.SchemaDescriptionBuilder(s),
+		Validators:          validators,
+		NestedObject: schema.NestedBlockObject{
+			Attributes: attributes,
+			Blocks:     blocks,
+		},
+	}
+}
+
+// resourceAttribute converts an attribute of the `conn` block of SDK
+// resources. Attributes with defaults are computed, and ForceNew attributes
+// require replacing the resource.
+func resourceAttribute(name string, attribute *sdkschema.Schema) schema.Attribute {
+	description := sdkschema.SchemaDescriptionBuilder(attribute)
+
+	switch attribute.Type {
+	case sdkschema.TypeString:
+		a := schema.StringAttribute{
+			Required:            attribute.Required,
+			Optional:            attribute.Optional,
+			Sensitive:           attribute.Sensitive,
+			MarkdownDescription: description,
+			Validators:          connectionStringValidators[name],
+		}
+		if attribute.Default != nil {
+			a.Computed = true
+			a.Default = stringdefault.StaticString(attribute.Default.(string))
+		}
+		if attribute.ForceNew {
+			a.PlanModifiers = []planmodifier.String{stringplanmodifier.RequiresReplace()}
+		}
+		return a
+	case sdkschema.TypeInt:
+		a := schema.Int64Attribute{
+			Required:            attribute.Required,
+			Optional:            attribute.Optional,
+			Sensitive:           attribute.Sensitive,
+			MarkdownDescription: description,
+			Validators:          connectionInt64Validators[name],
+		}
+		if attribute.Default != nil {
+			a.Computed = true
+			a.Default = int64default.StaticInt64(int64(attribute.Default.(int)))
+		}
+		if attribute.ForceNew {
+			a.PlanModifiers = []planmodifier.Int64{int64planmodifier.RequiresReplace()}
+		}
+		return a
+	case sdkschema.TypeBool:
+		a := schema.BoolAttribute{
+			Required:            attribute.Required,
+			Optional:            attribute.Optional,
+			Sensitive:           attribute.Sensitive,
+			MarkdownDescription: description,
+		}
+		if attribute.Default != nil {
+			a.Computed = true
+			a.Default = booldefault.StaticBool(attribute.Default.(bool))
+		}
+		if attribute.ForceNew {
+			a.PlanModifiers = []planmodifier.Bool{boolplanmodifier.RequiresReplace()}
+		}
+		return a
+	case sdkschema.TypeList:
+		return schema.ListAttribute{
+			ElementType:         elementType(attribute),
+			Required:            attribute.Required,
+			Optional:            attribute.Optional,
+			Sensitive:           attribute.Sensitive,
+			MarkdownDescription: description,
+		}
+	case sdkschema.TypeMap:
+		return schema.MapAttribute{
+			ElementType:         elementType(attribute),
+			Required:            attribute.Required,
+			Optional:            attribute.Optional,
+			Sensitive:           attribute.Sensitive,
+			MarkdownDescription: description,
+		}
+	default:
+		panic(fmt.Sprintf("unsupported type %s of conn attribute %s", attribute.Type, name))
+	}
+}
+
+// dataSourceConnectionBlock returns the `conn` block of data sources built on
+// the framework, which is the block of resources without defaults and plan
+// modifiers. Defaults are instead applied when reading the block.
+func dataSourceConnectionBlock(description string) dsschema.ListNestedBlock {
+	return dataSourceBlock(connectionBlock(description))
+}
+
+func dataSourceBlock(block schema.ListNestedBlock) dsschema.ListNestedBlock {
+	attributes := map[string]dsschema.Attribute{}
+	for name, attribute := range block.NestedObject.Attributes {
+		attributes[name] = dataSourceAttribute(attribute)
+	}
+
+	blocks := map[string]dsschema.Block{}
+	for name, nested := range block.NestedObject.Blocks {
+		blocks[name] = dataSourceBlock(nested.(schema.ListNestedBlock))
+	}
+
+	return dsschema.ListNestedBlock{
+		MarkdownDescription: block.MarkdownDescription,
+		Validators:          block.Validators,
+		NestedObject: dsschema.NestedBlockObject{
+			Attributes: attributes,
+			Blocks:     blocks,
+		},
+	}
+}
+
+// dataSourceAttribute converts the types of attributes used in the `conn`
+// block of resources.
+func dataSourceAttribute(attribute schema.Attribute) dsschema.Attribute {
+	switch a := attribute.(type) {
+	case schema.StringAttribute:
+		return dsschema.StringAttribute{
+			Required:            a.Required,
+			Optional:            a.Optional,
+			Sensitive:           a.Sensitive,
+			MarkdownDescription: a.MarkdownDescription,
+			Validators:          a.Validators,
+		}
+	case schema.Int64Attribute:
+		return dsschema.Int64Attribute{
+			Required:            a.Required,
+			Optional:            a.Optional,
+			Sensitive:           a.Sensitive,
+			MarkdownDescription: a.MarkdownDescription,
+			Validators:          a.Validators,
+		}
+	case schema.BoolAttribute:
+		return dsschema.BoolAttribute{
+			Required:            a.Required,
+			Optional:            a.Optional,
+			Sensitive:           a.Sensitive,
+			MarkdownDescription: a.MarkdownDescription,
+			Validators:          a.Validators,
+		}
+	case schema.ListAttribute:
+		return dsschema.ListAttribute{
+			ElementType:         a.ElementType,
+			Required:            a.Required,
+			Optional:            a.Optional,
+			Sensitive:           a.Sensitive,
+			MarkdownDescription: a.MarkdownDescription,
+			Validators:          a.Validators,
+		}
+	case schema.MapAttribute:
+		return dsschema.MapAttribute{
+			ElementType:         a.ElementType,
+			Required:            a.Required,
+			Optional:            a.Optional,
+			Sensitive:           a.Sensitive,
+			MarkdownDescription: a.MarkdownDescription,
+			Validators:          a.Validators,
+		}
+	default:
+		panic(fmt.Sprintf("unsupported attribute type %T in conn", attribute))
+	}
+}
+
+// hostConnectionModel holds the attributes of a host in `conn` blocks of
+// resources and data sources built on the framework.
+type hostConnectionModel struct {
+	Host                       types.String `tfsdk:"host"`
+	Port                       types.Int64  `tfsdk:"port"`
+	Timeout                    types.Int64  `tfsdk:"timeout"`
+	User                       types.String `tfsdk:"user"`
+	Agent                      types.Bool   `tfsdk:"agent"`
+	AgentSocket                types.String `tfsdk:"agent_socket"`
+	AgentIdentity              types.String `tfsdk:"agent_identity"`
+	Password                   types.String `tfsdk:"password"`
+	KeyboardInteractiveAnswers types.Map    `tfsdk:"keyboard_interactive_answers"`
+	PrivateKey                 types.String `tfsdk:"private_key"`
+	PrivateKeyPass             types.String `tfsdk:"private_key_pass"`
+	PrivateKeyPath             types.String `tfsdk:"private_key_path"`
+	PrivateKeyEnvVar           types.String `tfsdk:"private_key_env_var"`
+	Certificate                types.String `tfsdk:"certificate"`
+	CertificatePath            types.String `tfsdk:"certificate_path"`
+	HostCAPublicKey            types.String `tfsdk:"host_ca_public_key"`
+	HostKey                    types.String `tfsdk:"host_key"`
+	KnownHostsPath             types.String `tfsdk:"known_hosts_path"`
+	HostKeyAlgorithms          types.List   `tfsdk:"host_key_algorithms"`
+	StrictHostKeyChecking      types.String `tfsdk:"strict_host_key_checking"`
+}
+
+// connectionModel holds the attributes of `conn` blocks of resources and
+// data sources built on the framework.
+type connectionModel struct {
+	hostConnectionModel
+	Sudo           types.Bool            `tfsdk:"sudo"`
+	SFTPServerPath types.String          `tfsdk:"sftp_server_path"`
+	Become         []becomeModel         `tfsdk:"become"`
+	Retry          []retryModel          `tfsdk:"retry"`
+	SSHConfig      types.String          `tfsdk:"ssh_config"`
+	ProxyJump      []hostConnectionModel `tfsdk:"proxy_jump"`
+}
+
+type becomeModel struct {
+	Method   types.String `tfsdk:"method"`
+	User     types.String `tfsdk:"user"`
+	Password types.String `tfsdk:"password"`
+	Flags    types.List   `tfsdk:"flags"`
+}
+
+type retryModel struct {
+	MaxAttempts    types.Int64 `tfsdk:"max_attempts"`
+	InitialBackoff types.Int64 `tfsdk:"initial_backoff"`
+	MaxBackoff     types.Int64 `tfsdk:"max_backoff"`
+	Timeout        types.Int64 `tfsdk:"timeout"`
+}
+
+// connectionFromModel returns the connection in the `conn` block, which is
+// nil when the block is not set. Attributes of data sources have no
+// defaults, so null attributes are read as their default.
+func connectionFromModel(ctx context.Context, conn []connectionModel) (*connection, diag.Diagnostics) {
+	if len(conn) == 0 {
+		return nil, nil
+	}
+	m := conn[0]
+
+	host, diags := m.hostConnection(ctx)
+	if diags.HasError() {
+		return nil, diags
+	}
+
+	c := &connection{
+		hostConnection: host,
+		sudo:           m.Sudo.ValueBool() || len(m.Become) > 0,
+		become:         &become{method: "sudo"},
+		sftpServerPath: m.SFTPServerPath.ValueString(),
+		sshConfig:      m.SSHConfig.ValueString(),
+	}
+
+	if len(m.Become) > 0 {
+		b := m.Become[0]
+		c.become = &become{
+			method:   stringOrDefault(b.Method, "sudo"),
+			user:     b.User.ValueString(),
+			password: b.Password.ValueString(),
+		}
+		diags.Append(b.Flags.ElementsAs(ctx, &c.become.flags, false)...)
+		if err := c.become.validate(); err != nil {
+			diags.AddError("invalid become", err.Error())
+		}
+	}
+
+	if len(m.Retry) > 0 {
+		r := m.Retry[0]
+		c.retry = &retryPolicy{
+			maxAttempts:    int(int64OrDefault(r.MaxAttempts, 5)),
+			initialBackoff: time.Duration(int64OrDefault(r.InitialBackoff, 1000)) * time.Millisecond,
+			maxBackoff:     time.Duration(int64OrDefault(r.MaxBackoff, 30000)) * time.Millisecond,
+			timeout:        time.Duration(r.Timeout.ValueInt64()) * time.Millisecond,
+		}
+	}
+
+	for _, jump := range m.ProxyJump {
+		h, jumpDiags := jump.hostConnection(ctx)
+		diags.Append(jumpDiags...)
+		c.proxyJumps = append(c.proxyJumps, h)
+	}
+
+	if diags.HasError() {
+		return nil, diags
+	}
+	return c, diags
+}
+
+func (m *hostConnectionModel) hostConnection(ctx context.Context) (hostConnection, diag.Diagnostics) {
+	h := hostConnection{
+		host:                  m.Host.ValueString(),
+		port:                  int(int64OrDefault(m.Port, 22)),
+		timeout:               int(m.Timeout.ValueInt64()),
+		user:                  m.User.ValueString(),
+		agent:                 m.Agent.ValueBool(),
+		agentSocket:           m.AgentSocket.ValueString(),
+		agentIdentity:         m.AgentIdentity.ValueString(),
+		password:              m.Password.ValueString(),
+		privateKey:            m.PrivateKey.ValueString(),
+		privateKeyPass:        m.PrivateKeyPass.ValueString(),
+		privateKeyPath:        m.PrivateKeyPath.ValueString(),
+		privateKeyEnvVar:      m.PrivateKeyEnvVar.ValueString(),
+		certificate:           m.Certificate.ValueString(),
+		certificatePath:       m.CertificatePath.ValueString(),
+		hostCAPublicKey:       m.HostCAPublicKey.ValueString(),
+		hostKey:               m.HostKey.ValueString(),
+		knownHostsPath:        m.KnownHostsPath.ValueString(),
+		strictHostKeyChecking: m.StrictHostKeyChecking.ValueString(),
+	}
+
+	var diags diag.Diagnostics
+	diags.Append(m.KeyboardInteractiveAnswers.ElementsAs(ctx, &h.keyboardInteractiveAnswers, false)...)
+	diags.Append(m.HostKeyAlgorithms.ElementsAs(ctx, &h.hostKeyAlgorithms, false)...)
+	return h, diags
+}
+
+// nullZeroValues sets the attributes that the SDK stored as their zero value
+// when not set to null, as they are null in the configuration.
+func (m *connectionModel) nullZeroValues() {
+	m.hostConnectionModel.nullZeroValues()
+	m.SFTPServerPath = nullStringIfEmpty(m.SFTPServerPath)
+	m.SSHConfig = nullStringIfEmpty(m.SSHConfig)
+	for i := range m.Become {
+		m.Become[i].User = nullStringIfEmpty(m.Become[i].User)
+		m.Become[i].Password = nullStringIfEmpty(m.Become[i].Password)
+		m.Become[i].Flags = nullListIfEmpty(m.Become[i].Flags)
+	}
+	for i := range m.Retry {
+		m.Retry[i].Timeout = nullInt64IfZero(m.Retry[i].Timeout)
+	}
+	for i := range m.ProxyJump {
+		m.ProxyJump[i].nullZeroValues()
+	}
+}
+
+func (m *hostConnectionModel) nullZeroValues() {
+	m.Timeout = nullInt64IfZero(m.Timeout)
+	m.KeyboardInteractiveAnswers = nullMapIfEmpty(m.KeyboardInteractiveAnswers)
+	m.HostKeyAlgorithms = nullListIfEmpty(m.HostKeyAlgorithms)
+	for _, attribute := range []*types.String{
+		&m.User,
+		&m.AgentSocket,
+		&m.AgentIdentity,
+		&m.Password,
+		&m.PrivateKey,
+		&m.PrivateKeyPass,
+		&m.PrivateKeyPath,
+		&m.PrivateKeyEnvVar,
+		&m.Certificate,
+		&m.CertificatePath,
+		&m.HostCAPublicKey,
+		&m.HostKey,
+		&m.KnownHostsPath,
+		&m.StrictHostKeyChecking,
+	} {
+		*attribute = nullStringIfEmpty(*attribute)
+	}
+}
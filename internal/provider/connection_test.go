@@ -0,0 +1,436 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	fwschema "github.com/hashicorp/terraform-plugin-framework/resource/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// testSSHServer accepts SSH connections on a local port with config, and
+// refuses all channels once authenticated. It returns the port.
+func testSSHServer(t *testing.T, config *ssh.ServerConfig) int {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				sshConn, chans, reqs, err := ssh.NewServerConn(conn, config)
+				if err != nil {
+					return
+				}
+				defer sshConn.Close()
+				go ssh.DiscardRequests(reqs)
+				for newChannel := range chans {
+					_ = newChannel.Reject(ssh.Prohibited, "no channels in tests")
+				}
+			}()
+		}
+	}()
+
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+func testSigner(t *testing.T) (ssh.Signer, ed25519.PrivateKey) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer, private
+}
+
+func testCertificate(t *testing.T, ca ssh.Signer, key ssh.PublicKey, certType uint32, principal string) *ssh.Certificate {
+	certificate := &ssh.Certificate{
+		Key:             key,
+		CertType:        certType,
+		ValidPrincipals: []string{principal},
+		ValidBefore:     ssh.CertTimeInfinity,
+	}
+	if err := certificate.SignCert(rand.Reader, ca); err != nil {
+		t.Fatal(err)
+	}
+	return certificate
+}
+
+func testConnection(t *testing.T, conn map[string]interface{}) *connection {
+	c, err := connectionFromResourceData(schema.TestResourceDataRaw(t, New("dev")().Schema, map[string]interface{}{
+		"conn": []interface{}{conn},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestConnectionCertificates(t *testing.T) {
+	ca, _ := testSigner(t)
+	otherCA, _ := testSigner(t)
+	hostSigner, _ := testSigner(t)
+	userSigner, userKey := testSigner(t)
+
+	hostCertSigner, err := ssh.NewCertSigner(testCertificate(t, ca, hostSigner.PublicKey(), ssh.HostCert, "127.0.0.1"), hostSigner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userCertificate := testCertificate(t, ca, userSigner.PublicKey(), ssh.UserCert, "alice")
+
+	userChecker := &ssh.CertChecker{
+		IsUserAuthority: func(auth ssh.PublicKey) bool {
+			return bytes.Equal(auth.Marshal(), ca.PublicKey().Marshal())
+		},
+	}
+	serverConfig := &ssh.ServerConfig{PublicKeyCallback: userChecker.Authenticate}
+	serverConfig.AddHostKey(hostCertSigner)
+	port := testSSHServer(t, serverConfig)
+
+	pemKey, err := ssh.MarshalPrivateKey(userKey, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		conn    map[string]interface{}
+		wantErr bool
+	}{
+		{
+			name: "certificate signed by the CAs",
+			conn: map[string]interface{}{
+				"certificate":        string(ssh.MarshalAuthorizedKey(userCertificate)),
+				"host_ca_public_key": string(ssh.MarshalAuthorizedKey(ca.PublicKey())),
+			},
+		},
+		{
+			name: "no user certificate",
+			conn: map[string]interface{}{
+				"host_ca_public_key": string(ssh.MarshalAuthorizedKey(ca.PublicKey())),
+			},
+			wantErr: true,
+		},
+		{
+			name: "host certificate signed by another CA",
+			conn: map[string]interface{}{
+				"certificate":        string(ssh.MarshalAuthorizedKey(userCertificate)),
+				"host_ca_public_key": string(ssh.MarshalAuthorizedKey(otherCA.PublicKey())),
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		test.conn["host"] = "127.0.0.1"
+		test.conn["port"] = port
+		test.conn["user"] = "alice"
+		test.conn["private_key"] = string(pem.EncodeToMemory(pemKey))
+
+		address, clientConfig, _, err := testConnection(t, test.conn).remoteClientConfig(context.Background())
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		if address != "127.0.0.1:"+strconv.Itoa(port) {
+			t.Fatalf("%s: got address %s", test.name, address)
+		}
+
+		client, err := ssh.Dial("tcp", address, clientConfig)
+		if err == nil {
+			client.Close()
+		}
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: got error %v", test.name, err)
+		}
+	}
+}
+
+func TestConnectionCertificateMismatch(t *testing.T) {
+	ca, _ := testSigner(t)
+	_, userKey := testSigner(t)
+	otherSigner, _ := testSigner(t)
+
+	pemKey, err := ssh.MarshalPrivateKey(userKey, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn := testConnection(t, map[string]interface{}{
+		"host":        "127.0.0.1",
+		"user":        "alice",
+		"private_key": string(pem.EncodeToMemory(pemKey)),
+		"certificate": string(ssh.MarshalAuthorizedKey(testCertificate(t, ca, otherSigner.PublicKey(), ssh.UserCert, "alice"))),
+	})
+	if _, _, _, err := conn.remoteClientConfig(context.Background()); err == nil {
+		t.Errorf("expected error for a certificate of another key")
+	}
+}
+
+// testAgent serves keyring on a unix socket, and returns the path of the
+// socket.
+func testAgent(t *testing.T, keyring agent.Agent) string {
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_ = agent.ServeAgent(keyring, conn)
+			}()
+		}
+	}()
+
+	return socket
+}
+
+func TestConnectionAgentIdentity(t *testing.T) {
+	keyring := agent.NewKeyring()
+	var bobKey ssh.PublicKey
+	for _, comment := range []string{"alice", "carol", "bob"} {
+		signer, private := testSigner(t)
+		if err := keyring.Add(agent.AddedKey{PrivateKey: private, Comment: comment}); err != nil {
+			t.Fatal(err)
+		}
+		if comment == "bob" {
+			bobKey = signer.PublicKey()
+		}
+	}
+	socket := testAgent(t, keyring)
+
+	var offered atomic.Int32
+	hostSigner, _ := testSigner(t)
+	serverConfig := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			offered.Add(1)
+			if bytes.Equal(key.Marshal(), bobKey.Marshal()) {
+				return &ssh.Permissions{}, nil
+			}
+			return nil, errors.New("unknown key")
+		},
+	}
+	serverConfig.AddHostKey(hostSigner)
+	port := testSSHServer(t, serverConfig)
+
+	tests := []struct {
+		identity    string
+		wantErr     bool
+		wantOffered int32
+	}{
+		{identity: "bob", wantOffered: 1},
+		{identity: ssh.FingerprintSHA256(bobKey), wantOffered: 1},
+		{identity: "MD5:" + ssh.FingerprintLegacyMD5(bobKey), wantOffered: 1},
+		{identity: "alice", wantErr: true, wantOffered: 1},
+		{identity: "", wantOffered: 3},
+	}
+
+	for _, test := range tests {
+		offered.Store(0)
+		conn := map[string]interface{}{
+			"host":         "127.0.0.1",
+			"port":         port,
+			"user":         "bob",
+			"agent_socket": socket,
+		}
+		if test.identity != "" {
+			conn["agent_identity"] = test.identity
+		}
+
+		address, clientConfig, keyAgent, err := testConnection(t, conn).remoteClientConfig(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		client, err := ssh.Dial("tcp", address, clientConfig)
+		if err == nil {
+			client.Close()
+		}
+		if (err != nil) != test.wantErr {
+			t.Errorf("identity %q: got error %v", test.identity, err)
+		}
+		// The server may be asked whether a key is accepted before it is
+		// used to sign, which counts the same key twice.
+		if got := offered.Load(); got < test.wantOffered || got > 2*test.wantOffered {
+			t.Errorf("identity %q: offered %d keys, want %d", test.identity, got, test.wantOffered)
+		}
+
+		if err := keyAgent.Close(); err != nil {
+			t.Errorf("identity %q: closing agent: %s", test.identity, err)
+		}
+		if keyAgent.conn != nil {
+			t.Errorf("identity %q: agent connection was not closed", test.identity)
+		}
+	}
+}
+
+func TestConnectionKeyboardInteractive(t *testing.T) {
+	hostSigner, _ := testSigner(t)
+	serverConfig := &ssh.ServerConfig{
+		KeyboardInteractiveCallback: func(conn ssh.ConnMetadata, challenge ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+			answers, err := challenge("", "", []string{"Password: ", "Verification code: "}, []bool{false, true})
+			if err != nil {
+				return nil, err
+			}
+			if len(answers) != 2 || answers[0] != "secret" || answers[1] != "123456" {
+				return nil, errors.New("wrong answers")
+			}
+			return &ssh.Permissions{}, nil
+		},
+	}
+	serverConfig.AddHostKey(hostSigner)
+	port := testSSHServer(t, serverConfig)
+
+	tests := []struct {
+		name    string
+		conn    map[string]interface{}
+		wantErr bool
+	}{
+		{
+			name: "password and answer",
+			conn: map[string]interface{}{
+				"password":                     "secret",
+				"keyboard_interactive_answers": map[string]interface{}{"verification code": "123456"},
+			},
+		},
+		{
+			name: "answers only",
+			conn: map[string]interface{}{
+				"keyboard_interactive_answers": map[string]interface{}{"password": "secret", "code": "123456"},
+			},
+		},
+		{
+			name: "no answer to the verification code",
+			conn: map[string]interface{}{
+				"password": "secret",
+			},
+			wantErr: true,
+		},
+		{
+			name: "wrong password",
+			conn: map[string]interface{}{
+				"password":                     "wrong",
+				"keyboard_interactive_answers": map[string]interface{}{"code": "123456"},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		test.conn["host"] = "127.0.0.1"
+		test.conn["port"] = port
+		test.conn["user"] = "admin"
+
+		address, clientConfig, _, err := testConnection(t, test.conn).remoteClientConfig(context.Background())
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+
+		client, err := ssh.Dial("tcp", address, clientConfig)
+		if err == nil {
+			client.Close()
+		}
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: got error %v", test.name, err)
+		}
+	}
+}
+
+func TestConnectionBlock(t *testing.T) {
+	block := connectionBlock("")
+
+	// Validation functions and ForceNew of the SDK must be replaced by
+	// validators and plan modifiers.
+	var check func(path string, s map[string]*schema.Schema, attributes map[string]fwschema.Attribute, blocks map[string]fwschema.Block)
+	check = func(path string, s map[string]*schema.Schema, attributes map[string]fwschema.Attribute, blocks map[string]fwschema.Block) {
+		for name, attribute := range s {
+			if r, ok := attribute.Elem.(*schema.Resource); ok {
+				nested := blocks[name].(fwschema.ListNestedBlock)
+				if attribute.MaxItems > 0 && len(nested.Validators) == 0 {
+					t.Errorf("%s.%s: MaxItems is not validated", path, name)
+				}
+				check(path+"."+name, r.Schema, nested.NestedObject.Attributes, nested.NestedObject.Blocks)
+				continue
+			}
+
+			var validators, planModifiers int
+			switch a := attributes[name].(type) {
+			case fwschema.StringAttribute:
+				validators, planModifiers = len(a.Validators), len(a.PlanModifiers)
+			case fwschema.Int64Attribute:
+				validators, planModifiers = len(a.Validators), len(a.PlanModifiers)
+			case fwschema.BoolAttribute:
+				validators, planModifiers = len(a.Validators), len(a.PlanModifiers)
+			}
+			if (attribute.ValidateFunc != nil || attribute.ValidateDiagFunc != nil) && validators == 0 {
+				t.Errorf("%s.%s: validation function is not replaced by a validator", path, name)
+			}
+			if attribute.ForceNew && planModifiers == 0 {
+				t.Errorf("%s.%s: ForceNew is not replaced by a plan modifier", path, name)
+			}
+		}
+	}
+
+	check("conn", connectionSchema(), block.NestedObject.Attributes, block.NestedObject.Blocks)
+}
+
+func TestConnectionSSHConfigJumpID(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config")
+	config := "Host remote1 remote2\n  ProxyJump bastion\n\nHost bastion\n  User jump\n"
+	if err := os.WriteFile(path, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	jumpID := func(conn map[string]interface{}) string {
+		conn["user"] = "root"
+		conn["ssh_config"] = path
+		jumpHosts, err := testConnection(t, conn).sshConfigJumpHosts(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(jumpHosts) != 1 {
+			t.Fatalf("got %d jump hosts, want 1", len(jumpHosts))
+		}
+		return jumpHosts[0].id
+	}
+
+	id := jumpID(map[string]interface{}{"host": "remote1", "agent_socket": "/tmp/agent1"})
+	if got := jumpID(map[string]interface{}{"host": "remote2", "agent_socket": "/tmp/agent1"}); got != id {
+		t.Errorf("remote hosts with the same credentials got jump ids %q and %q, want them equal", id, got)
+	}
+	if got := jumpID(map[string]interface{}{"host": "remote1", "agent_socket": "/tmp/agent2"}); got == id {
+		t.Errorf("remote hosts with different agent sockets got jump id %q, want them different", id)
+	}
+	if got := jumpID(map[string]interface{}{"host": "remote1", "password": "password"}); got == id {
+		t.Errorf("remote hosts with different credentials got jump id %q, want them different", id)
+	}
+}
@@ -0,0 +1,101 @@
+package provider
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+func dataSourceRemoteCommand() *schema.Resource {
+	return &schema.Resource{
+		Description: "Output of a read-only command run on remote host.",
+
+		ReadContext: dataSourceRemoteCommandRead,
+
+		Schema: map[string]*schema.Schema{
+			"conn": {
+				Type:        schema.TypeList,
+				MinItems:    0,
+				MaxItems:    1,
+				Optional:    true,
+				Description: "Connection to host where the command is run.",
+				Elem:        connectionSchemaResource,
+			},
+			"command": {
+				Description: "Command to run. The command is run on every refresh, and should not change the remote host.",
+				Type:        schema.TypeString,
+				Required:    true,
+			},
+			"stdout": {
+				Description: "Standard output of the command.",
+				Type:        schema.TypeString,
+				Computed:    true,
+			},
+			"stderr": {
+				Description: "Standard error of the command.",
+				Type:        schema.TypeString,
+				Computed:    true,
+			},
+			"exit_code": {
+				Description: "Exit status of the command. A non-zero exit status does not fail the data source.",
+				Type:        schema.TypeInt,
+				Computed:    true,
+			},
+		},
+	}
+}
+
+func dataSourceRemoteCommandRead(ctx context.Context, d *schema.ResourceData, meta interface{}) (error diag.Diagnostics) {
+	conn, err := meta.(*apiClient).getConnWithDefault(d)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	client, err := meta.(*apiClient).getRemoteClient(ctx, conn)
+	if err != nil {
+		return diag.Errorf("unable to open remote client: %s", err.Error())
+	}
+	defer func() {
+		if err := meta.(*apiClient).closeRemoteClient(conn, client); err != nil {
+			error = append(error, diag.Errorf("unable to close remote client: %s", err.Error())...)
+		}
+	}()
+
+	sudo := conn.sudo
+
+	cmd, err := Get[string](d, "command")
+	if err != nil {
+		return diag.Diagnostics{{Severity: diag.Error, Summary: err.Error()}}
+	}
+
+	d.SetId(fmt.Sprintf("%s:%d:%x", conn.host, conn.port, sha256.Sum256([]byte(cmd))))
+
+	stdout, stderr, err := client.RunCommand(ctx, cmd, sudo)
+	exitCode := 0
+	if err != nil {
+		status, ok := exitStatus(err)
+		if !ok {
+			return diag.Diagnostics{{
+				Severity: diag.Error,
+				Summary:  fmt.Sprintf("unable to run command: %s", err.Error()),
+				Detail:   commandOutput(stdout, stderr),
+			}}
+		}
+		exitCode = status
+	}
+
+	if err := d.Set("stdout", stdout); err != nil {
+		return diag.FromErr(err)
+	}
+	if err := d.Set("stderr", stderr); err != nil {
+		return diag.FromErr(err)
+	}
+	if err := d.Set("exit_code", exitCode); err != nil {
+		return diag.FromErr(err)
+	}
+
+	return diag.Diagnostics{}
+}
@@ -0,0 +1,36 @@
+package provider
+
+import (
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+)
+
+func TestAccDataSourceRemoteCommand(t *testing.T) {
+	resource.UnitTest(t, resource.TestCase{
+		PreCheck:                 func() { testAccPreCheck(t) },
+		ProtoV5ProviderFactories: protoV5ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: `
+				data "remote_command" "data_command_1" {
+					conn {
+						host = "remotehost"
+						user = "root"
+						password = "password"
+					}
+					command = "echo 'it''s here' && echo missing >&2 && exit 3"
+				}
+				`,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr(
+						"data.remote_command.data_command_1", "stdout", "its here\n"),
+					resource.TestCheckResourceAttr(
+						"data.remote_command.data_command_1", "stderr", "missing\n"),
+					resource.TestCheckResourceAttr(
+						"data.remote_command.data_command_1", "exit_code", "3"),
+				),
+			},
+		},
+	})
+}
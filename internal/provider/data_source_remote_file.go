@@ -2,149 +2,188 @@ package provider
 
 import (
 	"context"
-
-	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
-	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"encoding/base64"
+	"errors"
+	"io/fs"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-framework/datasource"
+	"github.com/hashicorp/terraform-plugin-framework/datasource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/types"
 )
 
-func dataSourceRemoteFile() *schema.Resource {
-	return &schema.Resource{
-		Description: "File on remote host.",
+var _ datasource.DataSourceWithConfigure = &remoteFileDataSource{}
+
+func NewRemoteFileDataSource() datasource.DataSource {
+	return &remoteFileDataSource{}
+}
+
+type remoteFileDataSource struct {
+	client *apiClient
+}
+
+type remoteFileDataSourceModel struct {
+	ID            types.String      `tfsdk:"id"`
+	Conn          []connectionModel `tfsdk:"conn"`
+	Path          types.String      `tfsdk:"path"`
+	Content       types.String      `tfsdk:"content"`
+	ContentBase64 types.String      `tfsdk:"content_base64"`
+	SHA256        types.String      `tfsdk:"sha256"`
+	SHA1          types.String      `tfsdk:"sha1"`
+	MD5           types.String      `tfsdk:"md5"`
+	Size          types.Int64       `tfsdk:"size"`
+	Permissions   types.String      `tfsdk:"permissions"`
+	Group         types.String      `tfsdk:"group"`
+	GroupName     types.String      `tfsdk:"group_name"`
+	Owner         types.String      `tfsdk:"owner"`
+	OwnerName     types.String      `tfsdk:"owner_name"`
+}
 
-		ReadContext: dataSourceRemoteFileRead,
+func (d *remoteFileDataSource) Metadata(ctx context.Context, req datasource.MetadataRequest, resp *datasource.MetadataResponse) {
+	resp.TypeName = req.ProviderTypeName + "_file"
+}
 
-		Schema: map[string]*schema.Schema{
-			"conn": {
-				Type:        schema.TypeList,
-				MinItems:    0,
-				MaxItems:    1,
-				Optional:    true,
-				Description: "Connection to host where files are located.",
-				Elem:        connectionSchemaResource,
+func (d *remoteFileDataSource) Schema(ctx context.Context, req datasource.SchemaRequest, resp *datasource.SchemaResponse) {
+	resp.Schema = schema.Schema{
+		MarkdownDescription: "File on remote host.",
+
+		Blocks: map[string]schema.Block{
+			"conn": dataSourceConnectionBlock("Connection to host where files are located."),
+		},
+
+		Attributes: map[string]schema.Attribute{
+			"id": schema.StringAttribute{
+				MarkdownDescription: "The ID of this resource.",
+				Computed:            true,
+			},
+			"path": schema.StringAttribute{
+				MarkdownDescription: "Path to file on remote host.",
+				Required:            true,
+			},
+			"content": schema.StringAttribute{
+				MarkdownDescription: "Content of file.",
+				Computed:            true,
+			},
+			"content_base64": schema.StringAttribute{
+				MarkdownDescription: "Base64 encoded content of file, for binary content that is not valid UTF-8.",
+				Computed:            true,
+			},
+			"sha256": schema.StringAttribute{
+				MarkdownDescription: "SHA-256 hash of the content of file, in hex form.",
+				Computed:            true,
 			},
-			"path": {
-				Description: "Path to file on remote host.",
-				Type:        schema.TypeString,
-				Required:    true,
+			"sha1": schema.StringAttribute{
+				MarkdownDescription: "SHA-1 hash of the content of file, in hex form.",
+				Computed:            true,
 			},
-			"content": {
-				Description: "Content of file.",
-				Type:        schema.TypeString,
-				Computed:    true,
+			"md5": schema.StringAttribute{
+				MarkdownDescription: "MD5 hash of the content of file, in hex form.",
+				Computed:            true,
 			},
-			"permissions": {
-				Description: "Permissions of file (in octal form).",
-				Type:        schema.TypeString,
-				Computed:    true,
+			"size": schema.Int64Attribute{
+				MarkdownDescription: "Size of file in bytes.",
+				Computed:            true,
 			},
-			"group": {
-				Description: "Group ID (GID) of file owner.",
-				Type:        schema.TypeString,
-				Computed:    true,
+			"permissions": schema.StringAttribute{
+				MarkdownDescription: "Permissions of file (in octal form).",
+				Computed:            true,
 			},
-			"group_name": {
-				Description: "Group name of file owner.",
-				Type:        schema.TypeString,
-				Computed:    true,
+			"group": schema.StringAttribute{
+				MarkdownDescription: "Group ID (GID) of file owner.",
+				Computed:            true,
 			},
-			"owner": {
-				Description: "User ID (UID) of file owner.",
-				Type:        schema.TypeString,
-				Computed:    true,
+			"group_name": schema.StringAttribute{
+				MarkdownDescription: "Group name of file owner.",
+				Computed:            true,
 			},
-			"owner_name": {
-				Description: "User name of file owner.",
-				Type:        schema.TypeString,
-				Computed:    true,
+			"owner": schema.StringAttribute{
+				MarkdownDescription: "User ID (UID) of file owner.",
+				Computed:            true,
+			},
+			"owner_name": schema.StringAttribute{
+				MarkdownDescription: "User name of file owner.",
+				Computed:            true,
 			},
 		},
 	}
 }
 
-func dataSourceRemoteFileRead(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
-	conn, err := meta.(*apiClient).getConnWithDefault(d)
-	if err != nil {
-		return diag.FromErr(err)
-	}
-
-	if err := setResourceID(d, conn); err != nil {
-		return diag.FromErr(err)
+func (d *remoteFileDataSource) Configure(ctx context.Context, req datasource.ConfigureRequest, resp *datasource.ConfigureResponse) {
+	// The provider is not configured yet when validating the configuration.
+	if req.ProviderData == nil {
+		return
 	}
+	d.client = req.ProviderData.(*apiClient)
+}
 
-	client, err := meta.(*apiClient).getRemoteClient(ctx, conn)
-	if err != nil {
-		return diag.Errorf("unable to open remote client: %s", err.Error())
+func (d *remoteFileDataSource) Read(ctx context.Context, req datasource.ReadRequest, resp *datasource.ReadResponse) {
+	var data remoteFileDataSourceModel
+	resp.Diagnostics.Append(req.Config.Get(ctx, &data)...)
+	if resp.Diagnostics.HasError() {
+		return
 	}
 
-	sudo, _, err := GetOk[bool](conn, "conn.0.sudo")
-	if err != nil {
-		return diag.Diagnostics{{Severity: diag.Error, Summary: err.Error()}}
+	conn, diags := connectionFromModel(ctx, data.Conn)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
 	}
-
-	path, err := Get[string](d, "path")
+	conn, err := d.client.connWithDefault(conn)
 	if err != nil {
-		return diag.Diagnostics{{Severity: diag.Error, Summary: err.Error()}}
+		resp.Diagnostics.AddError("unable to connect", err.Error())
+		return
 	}
 
-	exists, err := client.FileExists(path, sudo)
+	client, err := d.client.getRemoteClient(ctx, conn)
 	if err != nil {
-		return diag.Errorf("unable to check if remote file exists: %s", err.Error())
-	}
-	if !exists {
-		return diag.Errorf("cannot read file, it does not exist")
+		resp.Diagnostics.AddError("unable to open remote client", err.Error())
+		return
 	}
+	defer func() {
+		if err := d.client.closeRemoteClient(conn, client); err != nil {
+			resp.Diagnostics.AddError("unable to close remote client", err.Error())
+		}
+	}()
 
-	content, err := client.ReadFile(path, sudo)
-	if err != nil {
-		return diag.Errorf("unable to read remote file: %s", err.Error())
-	}
-	if err := d.Set("content", content); err != nil {
-		return diag.FromErr(err)
-	}
+	sudo := conn.sudo
+	path := data.Path.ValueString()
 
-	permissions, err := client.ReadFilePermissions(path, sudo)
-	if err != nil {
-		return diag.Errorf("unable to read remote file permissions: %s", err.Error())
-	}
-	if err := d.Set("permissions", permissions); err != nil {
-		return diag.FromErr(err)
-	}
+	data.ID = types.StringValue(resourceID{host: conn.host, port: conn.port, path: path}.String())
 
-	owner, err := client.ReadFileOwner(path, sudo)
-	if err != nil {
-		return diag.Errorf("unable to read remote file owner: %s", err.Error())
+	info, err := client.Stat(ctx, path, sudo)
+	if errors.Is(err, fs.ErrNotExist) || (err == nil && !info.Mode.IsRegular()) {
+		resp.Diagnostics.AddError("cannot read file, it does not exist", path)
+		return
 	}
-	if err := d.Set("owner", owner); err != nil {
-		return diag.FromErr(err)
-	}
-
-	ownerName, err := client.ReadFileOwnerName(path, sudo)
 	if err != nil {
-		return diag.Errorf("unable to read remote file owner_name: %s", err.Error())
-	}
-	if err := d.Set("owner_name", ownerName); err != nil {
-		return diag.FromErr(err)
+		resp.Diagnostics.AddError("unable to stat remote file", err.Error())
+		return
 	}
 
-	group, err := client.ReadFileGroup(path, sudo)
+	content, err := client.ReadFile(ctx, path, sudo)
 	if err != nil {
-		return diag.Errorf("unable to read remote file group: %s", err.Error())
-	}
-	if err := d.Set("group", group); err != nil {
-		return diag.FromErr(err)
+		resp.Diagnostics.AddError("unable to read remote file", err.Error())
+		return
 	}
+	data.Content = types.StringValue(content)
+	data.ContentBase64 = types.StringValue(base64.StdEncoding.EncodeToString([]byte(content)))
 
-	groupName, err := client.ReadFileGroupName(path, sudo)
+	hashes, err := hashContent(strings.NewReader(content))
 	if err != nil {
-		return diag.Errorf("unable to read remote file group_name: %s", err.Error())
-	}
-	if err := d.Set("group_name", groupName); err != nil {
-		return diag.FromErr(err)
+		resp.Diagnostics.AddError("unable to hash content", err.Error())
+		return
 	}
+	data.SHA256 = types.StringValue(hashes.SHA256)
+	data.SHA1 = types.StringValue(hashes.SHA1)
+	data.MD5 = types.StringValue(hashes.MD5)
+	data.Size = types.Int64Value(hashes.Size)
 
-	if err := meta.(*apiClient).closeRemoteClient(conn); err != nil {
-		return diag.Errorf("unable to close remote client: %s", err.Error())
-	}
+	data.Permissions = types.StringValue(info.Permissions())
+	data.Owner = types.StringValue(strconv.Itoa(info.UID))
+	data.OwnerName = types.StringValue(info.UserName)
+	data.Group = types.StringValue(strconv.Itoa(info.GID))
+	data.GroupName = types.StringValue(info.GroupName)
 
-	return diag.Diagnostics{}
+	resp.Diagnostics.Append(resp.State.Set(ctx, &data)...)
 }
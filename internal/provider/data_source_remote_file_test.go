@@ -14,7 +14,7 @@ func TestAccDataSourceRemoteFile(t *testing.T) {
 			testAccPreCheck(t)
 			writeFileToHost("remotehost:22", "/tmp/data_1.txt", "data_1", "root", "bob")
 		},
-		ProviderFactories: providerFactories,
+		ProtoV5ProviderFactories: protoV5ProviderFactories,
 		Steps: []resource.TestStep{
 			{
 				Config: `
@@ -54,7 +54,7 @@ func TestAccDataSourceRemoteFileOverridingDefaultConnection(t *testing.T) {
 			testAccPreCheck(t)
 			writeFileToHost("remotehost2:22", "/tmp/data_2.txt", "data_2", "root", "root")
 		},
-		ProviderFactories: providerFactories,
+		ProtoV5ProviderFactories: protoV5ProviderFactories,
 		Steps: []resource.TestStep{
 			{
 				Config: `
@@ -87,7 +87,7 @@ func TestAccDataSourceRemoteFilePrivateKey(t *testing.T) {
 			testAccPreCheck(t)
 			writeFileToHost("remotehost:22", "/tmp/data_3.txt", "data_3", "root", "root")
 		},
-		ProviderFactories: providerFactories,
+		ProtoV5ProviderFactories: protoV5ProviderFactories,
 		Steps: []resource.TestStep{
 			{
 				Config: `
@@ -158,7 +158,7 @@ func TestAccDataSourceRemoteFilePrivateKeyPath(t *testing.T) {
 			testAccPreCheck(t)
 			writeFileToHost("remotehost:22", "/tmp/data_4.txt", "data_4", "root", "root")
 		},
-		ProviderFactories: providerFactories,
+		ProtoV5ProviderFactories: protoV5ProviderFactories,
 		Steps: []resource.TestStep{
 			{
 				Config: `
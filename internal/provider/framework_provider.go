@@ -0,0 +1,172 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/datasource"
+	"github.com/hashicorp/terraform-plugin-framework/provider"
+	providerschema "github.com/hashicorp/terraform-plugin-framework/provider/schema"
+	"github.com/hashicorp/terraform-plugin-framework/providerserver"
+	"github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-go/tfprotov5"
+	"github.com/hashicorp/terraform-plugin-mux/tf5muxserver"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// NewMuxServer returns a server serving the resources and data sources of
+// both the SDK provider and the framework provider, during the migration to
+// the framework.
+func NewMuxServer(ctx context.Context, version string, primary *schema.Provider) (func() tfprotov5.ProviderServer, error) {
+	server, err := tf5muxserver.NewMuxServer(ctx,
+		// The SDK provider is configured first, as the framework provider
+		// shares its connections.
+		primary.GRPCProvider,
+		providerserver.NewProtocol5(NewFramework(version, primary)),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return server.ProviderServer, nil
+}
+
+// frameworkProvider provides the resources and data sources migrated to the
+// framework. It has the schema of the SDK provider, and uses the apiClient
+// configured by it.
+type frameworkProvider struct {
+	version string
+	primary *schema.Provider
+}
+
+var _ provider.Provider = &frameworkProvider{}
+
+func NewFramework(version string, primary *schema.Provider) provider.Provider {
+	return &frameworkProvider{
+		version: version,
+		primary: primary,
+	}
+}
+
+func (p *frameworkProvider) Metadata(ctx context.Context, req provider.MetadataRequest, resp *provider.MetadataResponse) {
+	resp.TypeName = "remote"
+	resp.Version = p.version
+}
+
+func (p *frameworkProvider) Schema(ctx context.Context, req provider.SchemaRequest, resp *provider.SchemaResponse) {
+	attributes, blocks := providerSchema(p.primary.Schema)
+	resp.Schema = providerschema.Schema{
+		Attributes: attributes,
+		Blocks:     blocks,
+	}
+}
+
+// Configure shares the apiClient of the SDK provider with the resources and
+// data sources, so that connections are pooled across both providers. The
+// configuration is validated and read by the SDK provider.
+func (p *frameworkProvider) Configure(ctx context.Context, req provider.ConfigureRequest, resp *provider.ConfigureResponse) {
+	client, ok := p.primary.Meta().(*apiClient)
+	if !ok || client == nil {
+		resp.Diagnostics.AddError("unable to configure provider", "the SDK provider must be configured before the framework provider")
+		return
+	}
+	resp.ResourceData = client
+	resp.DataSourceData = client
+}
+
+func (p *frameworkProvider) Resources(ctx context.Context) []func() resource.Resource {
+	return []func() resource.Resource{
+		NewRemoteFileResource,
+	}
+}
+
+func (p *frameworkProvider) DataSources(ctx context.Context) []func() datasource.DataSource {
+	return []func() datasource.DataSource{
+		NewRemoteFileDataSource,
+	}
+}
+
+// providerSchema converts the schema of the SDK provider, as the schemas of
+// muxed providers must be identical.
+func providerSchema(s map[string]*schema.Schema) (map[string]providerschema.Attribute, map[string]providerschema.Block) {
+	attributes := map[string]providerschema.Attribute{}
+	blocks := map[string]providerschema.Block{}
+
+	for name, attribute := range s {
+		description := schema.SchemaDescriptionBuilder(attribute)
+
+		if r, ok := attribute.Elem.(*schema.Resource); ok {
+			nestedAttributes, nestedBlocks := providerSchema(r.Schema)
+			blocks[name] = providerschema.ListNestedBlock{
+				MarkdownDescription: description,
+				NestedObject: providerschema.NestedBlockObject{
+					Attributes: nestedAttributes,
+					Blocks:     nestedBlocks,
+				},
+			}
+			continue
+		}
+
+		switch attribute.Type {
+		case schema.TypeString:
+			attributes[name] = providerschema.StringAttribute{
+				Required:            attribute.Required,
+				Optional:            attribute.Optional,
+				Sensitive:           attribute.Sensitive,
+				MarkdownDescription: description,
+			}
+		case schema.TypeInt:
+			attributes[name] = providerschema.Int64Attribute{
+				Required:            attribute.Required,
+				Optional:            attribute.Optional,
+				Sensitive:           attribute.Sensitive,
+				MarkdownDescription: description,
+			}
+		case schema.TypeBool:
+			attributes[name] = providerschema.BoolAttribute{
+				Required:            attribute.Required,
+				Optional:            attribute.Optional,
+				Sensitive:           attribute.Sensitive,
+				MarkdownDescription: description,
+			}
+		case schema.TypeList:
+			attributes[name] = providerschema.ListAttribute{
+				ElementType:         elementType(attribute),
+				Required:            attribute.Required,
+				Optional:            attribute.Optional,
+				Sensitive:           attribute.Sensitive,
+				MarkdownDescription: description,
+			}
+		case schema.TypeMap:
+			attributes[name] = providerschema.MapAttribute{
+				ElementType:         elementType(attribute),
+				Required:            attribute.Required,
+				Optional:            attribute.Optional,
+				Sensitive:           attribute.Sensitive,
+				MarkdownDescription: description,
+			}
+		default:
+			panic(fmt.Sprintf("unsupported type %s of provider attribute %s", attribute.Type, name))
+		}
+	}
+
+	return attributes, blocks
+}
+
+// elementType returns the type of the elements of lists and maps of
+// primitives, which are strings unless set otherwise.
+func elementType(attribute *schema.Schema) attr.Type {
+	elem, ok := attribute.Elem.(*schema.Schema)
+	if !ok {
+		return types.StringType
+	}
+	switch elem.Type {
+	case schema.TypeInt:
+		return types.Int64Type
+	case schema.TypeBool:
+		return types.BoolType
+	default:
+		return types.StringType
+	}
+}
@@ -0,0 +1,214 @@
+package provider
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// knownHostsMux serializes access to known hosts files, as hosts may be added
+// by concurrent connections when using `accept-new`.
+var knownHostsMux sync.Mutex
+
+// setHostKeyCallback verifies the public key of the host at address. The key
+// in `host_key` is ignored unless useHostKey, such as for jump hosts found in
+// `ssh_config` which only share the known hosts settings of the remote host.
+func (h *hostConnection) setHostKeyCallback(address string, clientConfig *ssh.ClientConfig, useHostKey bool) error {
+	hostKeyOk := h.hostKey != "" && useHostKey
+	knownHostsPath := h.knownHostsPath
+
+	strict := h.strictHostKeyChecking
+	if strict == "" {
+		strict = "no"
+		if knownHostsPath != "" {
+			strict = "yes"
+		}
+	}
+
+	clientConfig.HostKeyAlgorithms = append(clientConfig.HostKeyAlgorithms, h.hostKeyAlgorithms...)
+
+	switch {
+	case hostKeyOk:
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(h.hostKey))
+		if err != nil {
+			return fmt.Errorf("couldn't parse host key: %s", err.Error())
+		}
+		clientConfig.HostKeyCallback = ssh.FixedHostKey(key)
+		if len(clientConfig.HostKeyAlgorithms) == 0 {
+			clientConfig.HostKeyAlgorithms = hostKeyAlgorithms(key.Type())
+		}
+	case strict == "no":
+		clientConfig.HostKeyCallback = ssh.InsecureIgnoreHostKey()
+	default:
+		if knownHostsPath == "" {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return fmt.Errorf("couldn't find known hosts file: %s", err.Error())
+			}
+			knownHostsPath = filepath.Join(home, ".ssh", "known_hosts")
+		}
+
+		callback, knownAlgorithms, err := knownHostsCallback(knownHostsPath, address, strict == "accept-new")
+		if err != nil {
+			return err
+		}
+		clientConfig.HostKeyCallback = callback
+		if len(clientConfig.HostKeyAlgorithms) == 0 {
+			clientConfig.HostKeyAlgorithms = knownAlgorithms
+		}
+	}
+
+	if h.hostCAPublicKey != "" {
+		hostCA, _, _, _, err := ssh.ParseAuthorizedKey([]byte(h.hostCAPublicKey))
+		if err != nil {
+			return fmt.Errorf("couldn't parse host CA public key: %s", err.Error())
+		}
+
+		fallback := clientConfig.HostKeyCallback
+		if !hostKeyOk && strict == "no" {
+			fallback = func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+				return fmt.Errorf("host key of %s is not a certificate signed by the host CA", hostname)
+			}
+		}
+
+		checker := &ssh.CertChecker{
+			IsHostAuthority: func(auth ssh.PublicKey, address string) bool {
+				return bytes.Equal(auth.Marshal(), hostCA.Marshal())
+			},
+			HostKeyFallback: fallback,
+		}
+		clientConfig.HostKeyCallback = checker.CheckHostKey
+
+		// Prefer certificates over the known keys, unless the algorithms are
+		// set explicitly.
+		if len(h.hostKeyAlgorithms) == 0 && len(clientConfig.HostKeyAlgorithms) > 0 {
+			clientConfig.HostKeyAlgorithms = append(certHostKeyAlgorithms(), clientConfig.HostKeyAlgorithms...)
+		}
+	}
+
+	return nil
+}
+
+func certHostKeyAlgorithms() []string {
+	return []string{
+		ssh.CertAlgoED25519v01,
+		ssh.CertAlgoECDSA256v01,
+		ssh.CertAlgoECDSA384v01,
+		ssh.CertAlgoECDSA521v01,
+		ssh.CertAlgoRSASHA512v01,
+		ssh.CertAlgoRSASHA256v01,
+	}
+}
+
+// knownHostsCallback verifies host keys against the known hosts file at path.
+// It also returns the algorithms of the keys known for address, which should
+// be preferred during the handshake to avoid being presented with another key
+// type than the one that is known.
+func knownHostsCallback(path string, address string, acceptNew bool) (ssh.HostKeyCallback, []string, error) {
+	knownHostsMux.Lock()
+	defer knownHostsMux.Unlock()
+
+	if acceptNew {
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return nil, nil, fmt.Errorf("couldn't create known hosts file: %s", err.Error())
+		}
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return nil, nil, fmt.Errorf("couldn't create known hosts file: %s", err.Error())
+		}
+		file.Close()
+	}
+
+	callback, err := knownhosts.New(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("couldn't read known hosts file: %s", err.Error())
+	}
+	algorithms := knownHostKeyAlgorithms(callback, address)
+
+	if !acceptNew {
+		return callback, algorithms, nil
+	}
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		knownHostsMux.Lock()
+		defer knownHostsMux.Unlock()
+
+		// Read the file again, as the host may have been added by a concurrent
+		// connection since the callback was created.
+		callback, err := knownhosts.New(path)
+		if err != nil {
+			return fmt.Errorf("couldn't read known hosts file: %s", err.Error())
+		}
+
+		err = callback(hostname, remote, key)
+		var keyErr *knownhosts.KeyError
+		if !errors.As(err, &keyErr) || len(keyErr.Want) > 0 {
+			return err
+		}
+
+		file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+		if err != nil {
+			return fmt.Errorf("couldn't add host to known hosts file: %s", err.Error())
+		}
+		defer file.Close()
+
+		line := knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key)
+		if _, err := fmt.Fprintln(file, line); err != nil {
+			return fmt.Errorf("couldn't add host to known hosts file: %s", err.Error())
+		}
+		return nil
+	}, algorithms, nil
+}
+
+// knownHostKeyAlgorithms returns the algorithms of the keys known for address,
+// found by verifying a freshly generated key and inspecting the mismatch.
+func knownHostKeyAlgorithms(callback ssh.HostKeyCallback, address string) []string {
+	public, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil
+	}
+	placeholder, err := ssh.NewPublicKey(public)
+	if err != nil {
+		return nil
+	}
+
+	var keyErr *knownhosts.KeyError
+	if err := callback(address, &net.TCPAddr{}, placeholder); !errors.As(err, &keyErr) {
+		return nil
+	}
+
+	var algorithms []string
+	for _, known := range keyErr.Want {
+		for _, algorithm := range hostKeyAlgorithms(known.Key.Type()) {
+			if !slices.Contains(algorithms, algorithm) {
+				algorithms = append(algorithms, algorithm)
+			}
+		}
+	}
+	return algorithms
+}
+
+func hostKeyAlgorithms(keyType string) []string {
+	if keyType == ssh.KeyAlgoRSA {
+		return []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA}
+	}
+	return []string{keyType}
+}
+
+func marshalHostKey(key ssh.PublicKey) string {
+	if key == nil {
+		return ""
+	}
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+}
@@ -18,7 +18,7 @@ func TestMovingFileByModifyingProvider(t *testing.T) {
 		PreCheck: func() {
 			testAccPreCheck(t)
 		},
-		ProviderFactories: providerFactories,
+		ProtoV5ProviderFactories: protoV5ProviderFactories,
 		Steps: []resource.TestStep{
 			{
 				// Create file on 'remotehost'
@@ -67,7 +67,7 @@ func TestMovingFileByModifyingConn(t *testing.T) {
 		PreCheck: func() {
 			testAccPreCheck(t)
 		},
-		ProviderFactories: providerFactories,
+		ProtoV5ProviderFactories: protoV5ProviderFactories,
 		Steps: []resource.TestStep{
 			{
 				// Create file on 'remotehost'
@@ -7,9 +7,11 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"golang.org/x/crypto/ssh"
 )
 
 func init() {
@@ -32,10 +34,11 @@ func New(version string) func() *schema.Provider {
 	return func() *schema.Provider {
 		p := &schema.Provider{
 			DataSourcesMap: map[string]*schema.Resource{
-				"remote_file": dataSourceRemoteFile(),
+				"remote_command": dataSourceRemoteCommand(),
 			},
 			ResourcesMap: map[string]*schema.Resource{
-				"remote_file": resourceRemoteFile(),
+				"remote_directory": resourceRemoteDirectory(),
+				"remote_command":   resourceRemoteCommand(),
 			},
 			Schema: map[string]*schema.Schema{
 				"conn": {
@@ -46,11 +49,28 @@ func New(version string) func() *schema.Provider {
 					Description: "Default connection to host where files are located. Can be overridden in resources and data sources.",
 					Elem:        connectionSchemaResource,
 				},
+				"session_wait_timeout": {
+					Type:        schema.TypeInt,
+					Optional:    true,
+					Description: "The maximum amount of time, in milliseconds, to wait for a session when `max_sessions` sessions are in use. Timeout of zero means no timeout.",
+				},
+				"keepalive_interval": {
+					Type:        schema.TypeInt,
+					Optional:    true,
+					Default:     15000,
+					Description: "Interval, in milliseconds, between keepalive requests sent to each host. The connection is closed and opened again when a host does not reply within the interval. Interval of zero disables keepalive requests.",
+				},
+				"idle_timeout": {
+					Type:        schema.TypeInt,
+					Optional:    true,
+					Default:     30000,
+					Description: "The amount of time, in milliseconds, connections to hosts are kept open after their last session is released, to be reused by later resources and data sources. Timeout of zero closes connections as soon as they are unused.",
+				},
 				"max_sessions": {
 					Type:        schema.TypeInt,
 					Optional:    true,
 					Default:     3,
-					Description: "Maximum number of open sessions in each host connection.",
+					Description: "Maximum number of open sessions in each host connection. Includes the sessions of the SFTP clients shared by all resources and data sources on the host.",
 				},
 			},
 		}
@@ -62,173 +82,501 @@ func New(version string) func() *schema.Provider {
 }
 
 type apiClient struct {
-	resourceData   *schema.ResourceData
+	// The `conn` of the provider, which is nil when not set.
+	conn           *connection
 	mux            *sync.Mutex
 	remoteClients  map[string]*RemoteClient
 	activeSessions map[string]int
 	maxSessions    int
+	// Connections to jump hosts, keyed by the chain of jump hosts leading to
+	// them.
+	jumpClients map[string]*jumpClient
+	// The chain of jump hosts used by each remote client.
+	jumpChains map[string][]*jumpClient
+	// Closed and replaced whenever a session to the remote host is released,
+	// to wake up those waiting for a session.
+	sessionReleased    map[string]chan struct{}
+	sessionWaitTimeout time.Duration
+	// Timers closing connections without active sessions once they have been
+	// idle for idleTimeout.
+	idleTimers        map[string]*time.Timer
+	idleTimeout       time.Duration
+	keepaliveInterval time.Duration
+	// Lost connections replaced while sessions were still using them, which
+	// are closed once those sessions are released.
+	retiredClients map[*RemoteClient]*retiredClient
+	// Closed once the connection being established to the remote host is
+	// added to the pool or has failed, to wake up those waiting for it.
+	connecting map[string]chan struct{}
+}
+
+// retiredClient is a lost connection that is still used by sessions.
+type retiredClient struct {
+	sessions  int
+	jumpChain []*jumpClient
+}
+
+// jumpClient is a connection to a jump host, shared by the remote clients
+// behind it. Lost connections are removed from the pool, and closed once no
+// longer used.
+type jumpClient struct {
+	id     string
+	client *ssh.Client
+	done   chan struct{}
+	// The number of remote clients using the connection.
+	references int
+}
+
+// alive reports whether the connection to the jump host is still open.
+func (j *jumpClient) alive() bool {
+	select {
+	case <-j.done:
+		return false
+	default:
+		return true
+	}
 }
 
 func configure(version string, p *schema.Provider) func(context.Context, *schema.ResourceData) (interface{}, diag.Diagnostics) {
 	return func(c context.Context, d *schema.ResourceData) (interface{}, diag.Diagnostics) {
+		var conn *connection
+		if _, ok := d.GetOk("conn"); ok {
+			var err error
+			conn, err = connectionFromResourceData(d)
+			if err != nil {
+				return nil, diag.Diagnostics{{Severity: diag.Error, Summary: err.Error()}}
+			}
+		}
+
 		client := apiClient{
-			resourceData:   d,
-			maxSessions:    d.Get("max_sessions").(int),
-			mux:            &sync.Mutex{},
-			remoteClients:  map[string]*RemoteClient{},
-			activeSessions: map[string]int{},
+			conn:               conn,
+			maxSessions:        d.Get("max_sessions").(int),
+			mux:                &sync.Mutex{},
+			remoteClients:      map[string]*RemoteClient{},
+			activeSessions:     map[string]int{},
+			jumpClients:        map[string]*jumpClient{},
+			jumpChains:         map[string][]*jumpClient{},
+			sessionReleased:    map[string]chan struct{}{},
+			sessionWaitTimeout: time.Duration(d.Get("session_wait_timeout").(int)) * time.Millisecond,
+			idleTimers:         map[string]*time.Timer{},
+			retiredClients:     map[*RemoteClient]*retiredClient{},
+			connecting:         map[string]chan struct{}{},
+			idleTimeout:        time.Duration(d.Get("idle_timeout").(int)) * time.Millisecond,
+			keepaliveInterval:  time.Duration(d.Get("keepalive_interval").(int)) * time.Millisecond,
 		}
 
 		return &client, diag.Diagnostics{}
 	}
 }
 
-func (c *apiClient) getConnWithDefault(d *schema.ResourceData) (*schema.ResourceData, error) {
+func (c *apiClient) getConnWithDefault(d *schema.ResourceData) (*connection, error) {
 	if _, ok := d.GetOk("conn"); ok {
-		return d, nil
+		return connectionFromResourceData(d)
 	}
+	return c.connWithDefault(nil)
+}
 
-	c.mux.Lock()
-	defer c.mux.Unlock()
-
-	if _, ok := c.resourceData.GetOk("conn"); ok {
-		return c.resourceData, nil
+// connWithDefault returns conn, or the `conn` of the provider when conn is
+// nil.
+func (c *apiClient) connWithDefault(conn *connection) (*connection, error) {
+	if conn != nil {
+		return conn, nil
+	}
+	if c.conn != nil {
+		return c.conn, nil
 	}
-
 	return nil, errors.New("neither the provider nor the resource/data source have a configured connection")
 }
 
-func (c *apiClient) getRemoteClient(ctx context.Context, d *schema.ResourceData) (*RemoteClient, error) {
-	connectionID, err := resourceConnectionHash(d)
-	if err != nil {
-		return nil, err
+func (c *apiClient) getRemoteClient(ctx context.Context, conn *connection) (*RemoteClient, error) {
+	connectionID := conn.hash()
+
+	var timeout <-chan time.Time
+	if c.sessionWaitTimeout > 0 {
+		timer := time.NewTimer(c.sessionWaitTimeout)
+		defer timer.Stop()
+		timeout = timer.C
 	}
 
-	defer c.mux.Unlock()
 	for {
 		c.mux.Lock()
 
-		if client, ok := c.remoteClients[connectionID]; ok {
-			if c.activeSessions[connectionID] >= c.maxSessions {
+		client, ok := c.remoteClients[connectionID]
+		if ok && c.activeSessions[connectionID] == 0 {
+			if timer, ok := c.idleTimers[connectionID]; ok {
+				timer.Stop()
+				delete(c.idleTimers, connectionID)
+			}
+		}
+		if ok && !client.alive() {
+			// The connection was lost, so connect again. Errors closing the
+			// lost connection are of no interest.
+			_ = c.retireRemoteClient(connectionID)
+			ok = false
+		}
+		if !ok {
+			// Connect without holding the lock, as connecting is retried
+			// with backoff, while others wait for the same connection.
+			if connecting, ok := c.connecting[connectionID]; ok {
 				c.mux.Unlock()
-				continue
+				select {
+				case <-connecting:
+					continue
+				case <-ctx.Done():
+					return nil, fmt.Errorf("stopped waiting for a connection to the remote host: %w", ctx.Err())
+				}
 			}
-			c.activeSessions[connectionID]++
+			c.connecting[connectionID] = make(chan struct{})
+			c.mux.Unlock()
+			return c.connectRemoteClient(ctx, conn, connectionID)
+		}
 
+		// Sessions held by the shared SFTP clients count towards the
+		// maximum, while always allowing one active user.
+		if c.activeSessions[connectionID] < max(1, c.maxSessions-client.sftpSessions()) {
+			c.activeSessions[connectionID]++
+			c.mux.Unlock()
 			return client, nil
 		}
 
-		client, err := remoteClientFromResourceData(ctx, d)
-		if err != nil {
-			return nil, err
-		}
+		released := c.sessionReleased[connectionID]
+		c.mux.Unlock()
 
-		c.remoteClients[connectionID] = client
-		c.activeSessions[connectionID] = 1
-		return client, nil
+		select {
+		case <-released:
+		case <-ctx.Done():
+			return nil, fmt.Errorf("stopped waiting for a session to the remote host: %w", ctx.Err())
+		case <-timeout:
+			return nil, fmt.Errorf("timed out after %s waiting for one of the %d sessions to the remote host, increase max_sessions or session_wait_timeout", c.sessionWaitTimeout, c.maxSessions)
+		}
 	}
 }
 
-func remoteClientFromResourceData(ctx context.Context, d *schema.ResourceData) (*RemoteClient, error) {
-	host, clientConfig, err := ConnectionFromResourceData(ctx, d)
+// connectRemoteClient connects to the remote host, and adds the client to the
+// pool with one active session. Must be called without c.mux locked, after
+// registering the connection in c.connecting.
+func (c *apiClient) connectRemoteClient(ctx context.Context, conn *connection, connectionID string) (*RemoteClient, error) {
+	client, jumpChain, err := c.dialRemoteClient(ctx, conn)
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	close(c.connecting[connectionID])
+	delete(c.connecting, connectionID)
+
 	if err != nil {
 		return nil, err
 	}
-	return NewRemoteClient(host, clientConfig)
+
+	if c.keepaliveInterval > 0 {
+		client.keepAlive(c.keepaliveInterval)
+	}
+
+	c.remoteClients[connectionID] = client
+	c.jumpChains[connectionID] = jumpChain
+	c.activeSessions[connectionID] = 1
+	c.sessionReleased[connectionID] = make(chan struct{})
+	return client, nil
 }
 
-func (c *apiClient) closeRemoteClient(d *schema.ResourceData) error {
-	connectionID, err := resourceConnectionHash(d)
+// dialRemoteClient connects to the remote host through its jump hosts, if
+// any. Must be called without c.mux locked.
+func (c *apiClient) dialRemoteClient(ctx context.Context, conn *connection) (_ *RemoteClient, _ []*jumpClient, err error) {
+	host, clientConfig, keyAgent, err := conn.remoteClientConfig(ctx)
 	if err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		if err != nil {
+			err = errors.Join(err, keyAgent.Close())
+		}
+	}()
+
+	jumpHosts, err := conn.jumpHosts(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	jumpClient, jumpChain, err := c.getJumpClient(ctx, jumpHosts, conn.retry)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var client *RemoteClient
+	err = conn.retry.do(ctx, isDialError, func() (err error) {
+		client, err = NewRemoteClient(jumpClient, host, clientConfig)
 		return err
+	})
+	if err != nil {
+		c.mux.Lock()
+		defer c.mux.Unlock()
+		return nil, nil, errors.Join(err, c.releaseJumpClients(jumpChain))
+	}
+	client.become = conn.become
+	client.sftpServerPath = conn.sftpServerPath
+	client.retry = conn.retry
+	client.agent = keyAgent
+
+	return client, jumpChain, nil
+}
+
+// getJumpClient connects to each jump host through the previous one, reusing
+// existing connections to the same chain of jump hosts. It returns the
+// connection to the last jump host, which is nil when there are no jump
+// hosts, along with the chain that must be released when no longer used.
+// Connecting to the jump hosts is retried with retry. Must be called without
+// c.mux locked.
+func (c *apiClient) getJumpClient(ctx context.Context, jumpHosts []jumpHost, retry *retryPolicy) (*ssh.Client, []*jumpClient, error) {
+	var client *ssh.Client
+	jumpChain := []*jumpClient{}
+
+	for i, jumpHost := range jumpHosts {
+		id := jumpHost.id
+		if i > 0 {
+			id = fmt.Sprintf("%s->%s", jumpChain[i-1].id, id)
+		}
+
+		c.mux.Lock()
+		jump := c.liveJumpClient(id)
+		if jump != nil {
+			jump.references++
+		}
+		c.mux.Unlock()
+
+		if jump == nil {
+			var sshClient *ssh.Client
+			err := retry.do(ctx, isDialError, func() (err error) {
+				sshClient, err = dialSSH(client, jumpHost.address, jumpHost.clientConfig)
+				return err
+			})
+			// The agent is only used to login, as agent forwarding is not
+			// supported.
+			err = errors.Join(err, jumpHost.agent.Close())
+
+			c.mux.Lock()
+			if err != nil {
+				err = fmt.Errorf("couldn't establish a connection to the jump host '%s@%s': %s", jumpHost.clientConfig.User, jumpHost.address, err.Error())
+				err = errors.Join(err, c.releaseJumpClients(jumpChain))
+				c.mux.Unlock()
+				return nil, nil, err
+			}
+			// Keep the connection to the jump host made by someone else
+			// while connecting.
+			if jump = c.liveJumpClient(id); jump != nil {
+				_ = sshClient.Close()
+			} else {
+				jump = &jumpClient{id: id, client: sshClient, done: watchClosed(sshClient)}
+				if c.keepaliveInterval > 0 {
+					sendKeepalives(sshClient, jump.done, c.keepaliveInterval)
+				}
+				c.jumpClients[id] = jump
+			}
+			jump.references++
+			c.mux.Unlock()
+		}
+
+		client = jump.client
+		jumpChain = append(jumpChain, jump)
+	}
+
+	return client, jumpChain, nil
+}
+
+// liveJumpClient returns the connection to the jump host in the pool with id,
+// or nil when there is none. A lost connection is removed from the pool, to
+// connect again. Must be called with c.mux locked.
+func (c *apiClient) liveJumpClient(id string) *jumpClient {
+	jump, ok := c.jumpClients[id]
+	if !ok {
+		return nil
+	}
+	if !jump.alive() {
+		delete(c.jumpClients, id)
+		return nil
 	}
+	return jump
+}
+
+// releaseJumpClients closes the connections to the jump hosts in the chain
+// that are no longer used by any remote client. Errors closing lost
+// connections are of no interest. Must be called with c.mux locked.
+func (c *apiClient) releaseJumpClients(jumpChain []*jumpClient) error {
+	var errs []error
+	for i := len(jumpChain) - 1; i >= 0; i-- {
+		jump := jumpChain[i]
+		jump.references--
+		if jump.references > 0 {
+			continue
+		}
+		alive := jump.alive()
+		if err := jump.client.Close(); err != nil && alive {
+			errs = append(errs, err)
+		}
+		if c.jumpClients[jump.id] == jump {
+			delete(c.jumpClients, jump.id)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// closeRemoteClient releases the session of client, which was returned by
+// getRemoteClient for conn.
+func (c *apiClient) closeRemoteClient(conn *connection, client *RemoteClient) error {
+	connectionID := conn.hash()
 
 	c.mux.Lock()
 	defer c.mux.Unlock()
 
+	if retired, ok := c.retiredClients[client]; ok {
+		retired.sessions--
+		if retired.sessions > 0 {
+			return nil
+		}
+		delete(c.retiredClients, client)
+		return errors.Join(client.Close(), c.releaseJumpClients(retired.jumpChain))
+	}
+
 	c.activeSessions[connectionID]--
-	if c.activeSessions[connectionID] == 0 {
-		client := c.remoteClients[connectionID]
-		delete(c.remoteClients, connectionID)
-		return client.Close()
+
+	// Wake up everyone waiting for a session to the remote host.
+	if released, ok := c.sessionReleased[connectionID]; ok {
+		close(released)
+		c.sessionReleased[connectionID] = make(chan struct{})
+	}
+
+	if c.activeSessions[connectionID] > 0 {
+		return nil
+	}
+
+	if c.idleTimeout == 0 {
+		return c.removeRemoteClient(connectionID)
 	}
 
+	var timer *time.Timer
+	timer = time.AfterFunc(c.idleTimeout, func() {
+		c.mux.Lock()
+		defer c.mux.Unlock()
+
+		// The connection was reused, and possibly became idle again, after
+		// the timer fired.
+		if c.idleTimers[connectionID] != timer {
+			return
+		}
+		delete(c.idleTimers, connectionID)
+		// Nothing is left to report errors to once the connection is idle.
+		_ = c.removeRemoteClient(connectionID)
+	})
+	c.idleTimers[connectionID] = timer
+
 	return nil
 }
 
-func setResourceID(d *schema.ResourceData, conn *schema.ResourceData) error {
-	host, err := Get[string](conn, "conn.0.host")
-	if err != nil {
-		return err
+// retireRemoteClient removes the lost connection to the remote host from the
+// pool. It is closed once the sessions still using it are released. Must be
+// called with c.mux locked.
+func (c *apiClient) retireRemoteClient(connectionID string) error {
+	if c.activeSessions[connectionID] == 0 {
+		return c.removeRemoteClient(connectionID)
 	}
 
-	port, err := Get[int](conn, "conn.0.port")
-	if err != nil {
-		return err
+	client := c.remoteClients[connectionID]
+	c.retiredClients[client] = &retiredClient{
+		sessions:  c.activeSessions[connectionID],
+		jumpChain: c.jumpChains[connectionID],
 	}
 
+	// Wake up everyone waiting for a session, to use the new connection.
+	close(c.sessionReleased[connectionID])
+
+	delete(c.remoteClients, connectionID)
+	delete(c.jumpChains, connectionID)
+	delete(c.activeSessions, connectionID)
+	delete(c.sessionReleased, connectionID)
+	return nil
+}
+
+// removeRemoteClient closes the connection to the remote host and removes it
+// from the pool. Must be called with c.mux locked.
+func (c *apiClient) removeRemoteClient(connectionID string) error {
+	client := c.remoteClients[connectionID]
+	jumpChain := c.jumpChains[connectionID]
+	delete(c.remoteClients, connectionID)
+	delete(c.jumpChains, connectionID)
+	delete(c.activeSessions, connectionID)
+	delete(c.sessionReleased, connectionID)
+	return errors.Join(client.Close(), c.releaseJumpClients(jumpChain))
+}
+
+func setResourceID(d *schema.ResourceData, conn *connection) error {
 	path, err := Get[string](d, "path")
 	if err != nil {
 		return err
 	}
 
-	d.SetId(fmt.Sprintf("%s:%d:%s",
-		host,
-		port,
-		path,
-	))
+	d.SetId(resourceID{host: conn.host, port: conn.port, path: path}.String())
 
 	return nil
 }
 
-func resourceConnectionHash(d *schema.ResourceData) (string, error) {
-	host, err := Get[string](d, "conn.0.host")
-	if err != nil {
-		return "", err
+// matchResourceID returns an error unless conn connects to the host and port
+// in id, as the user in id if set.
+func matchResourceID(conn *connection, id resourceID) error {
+	if conn.host != id.host || conn.port != id.port || (id.user != "" && conn.user != id.user) {
+		return fmt.Errorf("unable to import %s from %s:%d, as the conn of the provider connects to %s@%s:%d", id.path, id.host, id.port, conn.user, conn.host, conn.port)
 	}
+	return nil
+}
 
-	user, err := Get[string](d, "conn.0.user")
-	if err != nil {
-		return "", err
-	}
+// resourceID identifies a file or directory on a remote host.
+type resourceID struct {
+	// Empty unless set in the ID.
+	user string
+	host string
+	port int
+	path string
+}
 
-	port, err := Get[int](d, "conn.0.port")
-	if err != nil {
-		return "", err
-	}
+// String returns the ID in the `host:port:path` format, without the user.
+func (r resourceID) String() string {
+	return fmt.Sprintf("%s:%d:%s", r.host, r.port, r.path)
+}
 
-	password, _, err := GetOk[string](d, "conn.0.password")
-	if err != nil {
-		return "", err
+// parseResourceID parses IDs in the `host:port:path` format set by
+// setResourceID, optionally prefixed by `user@`. The path may contain colons,
+// and IPv6 hosts are enclosed in brackets.
+func parseResourceID(id string) (resourceID, error) {
+	invalid := fmt.Errorf("invalid ID %q, expected host:port:path or user@host:port:path", id)
+
+	var r resourceID
+	if user, rest, ok := strings.Cut(id, "@"); ok && !strings.Contains(user, ":") {
+		r.user, id = user, rest
 	}
 
-	privateKey, _, err := GetOk[string](d, "conn.0.private_key")
-	if err != nil {
-		return "", err
+	if strings.HasPrefix(id, "[") {
+		host, rest, ok := strings.Cut(id[1:], "]")
+		if !ok || !strings.HasPrefix(rest, ":") {
+			return resourceID{}, invalid
+		}
+		r.host, id = host, rest[1:]
+	} else {
+		host, rest, ok := strings.Cut(id, ":")
+		if !ok {
+			return resourceID{}, invalid
+		}
+		r.host, id = host, rest
 	}
 
-	privateKeyPath, _, err := GetOk[string](d, "conn.0.private_key_path")
-	if err != nil {
-		return "", err
+	port, path, ok := strings.Cut(id, ":")
+	if !ok || r.host == "" || path == "" {
+		return resourceID{}, invalid
 	}
 
-	// Should ideally use Get as it has a default and should always exist.
-	// However GetOk as Terraform returns false for exists when value equals
-	// zero value (which the default for agent does). Could maybe use
-	// GetOkExists, but discouraged.
-	agent, _, err := GetOk[bool](d, "conn.0.agent")
+	var err error
+	r.port, err = strconv.Atoi(port)
 	if err != nil {
-		return "", err
+		return resourceID{}, invalid
 	}
+	r.path = path
 
-	elements := []string{
-		host,
-		user,
-		strconv.Itoa(port),
-		password,
-		privateKey,
-		privateKeyPath,
-		strconv.FormatBool(agent),
-	}
-	return strings.Join(elements, "::"), nil
+	return r, nil
 }
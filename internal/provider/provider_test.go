@@ -2,65 +2,402 @@ package provider
 
 import (
 	"context"
+	"errors"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/hashicorp/terraform-plugin-go/tfprotov5"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"golang.org/x/crypto/ssh"
 )
 
-// providerFactories are used to instantiate a provider during acceptance testing.
-// The factory function will be invoked for every Terraform CLI command executed
-// to create a provider server to which the CLI can reattach.
-var providerFactories = map[string]func() (*schema.Provider, error){
-	"remote": func() (*schema.Provider, error) {
-		return New("dev")(), nil
+// protoV5ProviderFactories are used to instantiate a provider during acceptance
+// testing. The factory function will be invoked for every Terraform CLI command
+// executed to create a provider server to which the CLI can reattach.
+var protoV5ProviderFactories = map[string]func() (tfprotov5.ProviderServer, error){
+	"remote": func() (tfprotov5.ProviderServer, error) {
+		return newTestServer(New("dev")())
 	},
-	"remotehost": func() (*schema.Provider, error) {
-		provider := New("dev")()
-		configureProvider := provider.ConfigureContextFunc
-		provider.ConfigureContextFunc = func(c context.Context, rd *schema.ResourceData) (interface{}, diag.Diagnostics) {
-			if err := rd.Set("conn", []interface{}{
-				map[string]interface{}{
-					"host":     "remotehost",
-					"user":     "root",
-					"password": "password",
-					"port":     22,
-				},
-			}); err != nil {
-				return nil, diag.FromErr(err)
-			}
-			return configureProvider(c, rd)
-		}
-		return provider, nil
+	"remotehost": func() (tfprotov5.ProviderServer, error) {
+		return newTestServer(withDefaultConn(New("dev")(), "remotehost"))
 	},
-	"remotehost2": func() (*schema.Provider, error) {
-		provider := New("dev")()
-		configureProvider := provider.ConfigureContextFunc
-		provider.ConfigureContextFunc = func(c context.Context, rd *schema.ResourceData) (interface{}, diag.Diagnostics) {
-			if err := rd.Set("conn", []interface{}{
-				map[string]interface{}{
-					"host":     "remotehost2",
-					"user":     "root",
-					"password": "password",
-					"port":     22,
-				},
-			}); err != nil {
-				return nil, diag.FromErr(err)
-			}
-			return configureProvider(c, rd)
-		}
-		return provider, nil
+	"remotehost2": func() (tfprotov5.ProviderServer, error) {
+		return newTestServer(withDefaultConn(New("dev")(), "remotehost2"))
 	},
 }
 
+// newTestServer muxes provider with the framework provider.
+func newTestServer(provider *schema.Provider) (tfprotov5.ProviderServer, error) {
+	server, err := NewMuxServer(context.Background(), "dev", provider)
+	if err != nil {
+		return nil, err
+	}
+	return server(), nil
+}
+
+// withDefaultConn sets the `conn` of provider to connect to host.
+func withDefaultConn(provider *schema.Provider, host string) *schema.Provider {
+	configureProvider := provider.ConfigureContextFunc
+	provider.ConfigureContextFunc = func(c context.Context, rd *schema.ResourceData) (interface{}, diag.Diagnostics) {
+		if err := rd.Set("conn", []interface{}{
+			map[string]interface{}{
+				"host":     host,
+				"user":     "root",
+				"password": "password",
+				"port":     22,
+			},
+		}); err != nil {
+			return nil, diag.FromErr(err)
+		}
+		return configureProvider(c, rd)
+	}
+	return provider
+}
+
 func TestProvider(t *testing.T) {
 	if err := New("dev")().InternalValidate(); err != nil {
 		t.Fatalf("err: %s", err)
 	}
 }
 
+func TestGetRemoteClientWaitsForSession(t *testing.T) {
+	conn := testConnection(t, map[string]interface{}{
+		"host":     "remotehost",
+		"user":     "root",
+		"password": "password",
+	})
+	connectionID := conn.hash()
+
+	client := &RemoteClient{}
+	c := &apiClient{
+		mux:                &sync.Mutex{},
+		remoteClients:      map[string]*RemoteClient{connectionID: client},
+		activeSessions:     map[string]int{connectionID: 1},
+		maxSessions:        1,
+		sessionReleased:    map[string]chan struct{}{connectionID: make(chan struct{})},
+		sessionWaitTimeout: 50 * time.Millisecond,
+	}
+
+	if _, err := c.getRemoteClient(context.Background(), conn); err == nil {
+		t.Errorf("expected timeout waiting for a session")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c.sessionWaitTimeout = 0
+	if _, err := c.getRemoteClient(ctx, conn); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected cancellation while waiting for a session, got %v", err)
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		c.mux.Lock()
+		c.activeSessions[connectionID]--
+		close(c.sessionReleased[connectionID])
+		c.sessionReleased[connectionID] = make(chan struct{})
+		c.mux.Unlock()
+	}()
+	got, err := c.getRemoteClient(context.Background(), conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != client || c.activeSessions[connectionID] != 1 {
+		t.Errorf("expected the released session to be reused")
+	}
+}
+
+func TestCloseRemoteClientKeepsIdleConnection(t *testing.T) {
+	conn := testConnection(t, map[string]interface{}{
+		"host":     "remotehost",
+		"user":     "root",
+		"password": "password",
+	})
+	connectionID := conn.hash()
+
+	client := &RemoteClient{}
+	c := &apiClient{
+		mux:             &sync.Mutex{},
+		remoteClients:   map[string]*RemoteClient{connectionID: client},
+		activeSessions:  map[string]int{connectionID: 1},
+		maxSessions:     1,
+		sessionReleased: map[string]chan struct{}{connectionID: make(chan struct{})},
+		idleTimers:      map[string]*time.Timer{},
+		idleTimeout:     time.Hour,
+	}
+
+	if err := c.closeRemoteClient(conn, client); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.idleTimers[connectionID]; !ok {
+		t.Fatalf("expected the idle connection to be kept open")
+	}
+
+	got, err := c.getRemoteClient(context.Background(), conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != client {
+		t.Errorf("expected the idle connection to be reused")
+	}
+	if _, ok := c.idleTimers[connectionID]; ok {
+		t.Errorf("expected the idle timer to be stopped when the connection is reused")
+	}
+}
+
+func TestGetRemoteClientReplacesLostConnection(t *testing.T) {
+	// Nothing listens on the port, so connecting again fails right away.
+	conn := testConnection(t, map[string]interface{}{
+		"host":     "127.0.0.1",
+		"port":     1,
+		"user":     "root",
+		"password": "password",
+	})
+	connectionID := conn.hash()
+
+	done := make(chan struct{})
+	close(done)
+	lost := &RemoteClient{done: done}
+	released := make(chan struct{})
+	c := &apiClient{
+		mux:             &sync.Mutex{},
+		remoteClients:   map[string]*RemoteClient{connectionID: lost},
+		activeSessions:  map[string]int{connectionID: 2},
+		maxSessions:     3,
+		sessionReleased: map[string]chan struct{}{connectionID: released},
+		jumpChains:      map[string][]*jumpClient{},
+		retiredClients:  map[*RemoteClient]*retiredClient{},
+		connecting:      map[string]chan struct{}{},
+	}
+
+	if _, err := c.getRemoteClient(context.Background(), conn); err == nil {
+		t.Fatalf("expected connecting again to fail")
+	}
+	if _, ok := c.remoteClients[connectionID]; ok {
+		t.Errorf("expected the lost connection to be removed from the pool")
+	}
+	if retired, ok := c.retiredClients[lost]; !ok || retired.sessions != 2 {
+		t.Fatalf("expected the lost connection to be kept for its 2 sessions")
+	}
+	select {
+	case <-released:
+	default:
+		t.Errorf("expected those waiting for a session to be woken up")
+	}
+
+	if err := c.closeRemoteClient(conn, lost); err != nil {
+		t.Fatal(err)
+	}
+	if retired := c.retiredClients[lost]; retired == nil || retired.sessions != 1 {
+		t.Errorf("expected the lost connection to be kept until its last session is released")
+	}
+}
+
+func TestGetJumpClientReplacesLostConnection(t *testing.T) {
+	// Nothing listens on the port, so connecting again fails right away.
+	jumpHosts := []jumpHost{{
+		id:           "root@127.0.0.1:1",
+		address:      "127.0.0.1:1",
+		clientConfig: &ssh.ClientConfig{User: "root", HostKeyCallback: ssh.InsecureIgnoreHostKey()},
+	}}
+
+	done := make(chan struct{})
+	close(done)
+	lost := &jumpClient{id: "root@127.0.0.1:1", client: &ssh.Client{}, done: done, references: 1}
+	c := &apiClient{
+		mux:         &sync.Mutex{},
+		jumpClients: map[string]*jumpClient{lost.id: lost},
+	}
+
+	if _, _, err := c.getJumpClient(context.Background(), jumpHosts, nil); err == nil {
+		t.Fatalf("expected connecting to the jump host again to fail")
+	}
+	if _, ok := c.jumpClients[lost.id]; ok {
+		t.Errorf("expected the lost connection to be removed from the pool")
+	}
+	if lost.references != 1 {
+		t.Errorf("expected the lost connection to be kept for the remote client using it")
+	}
+}
+
+func TestGetRemoteClientWaitsForConnection(t *testing.T) {
+	conn := testConnection(t, map[string]interface{}{
+		"host":     "remotehost",
+		"user":     "root",
+		"password": "password",
+	})
+	connectionID := conn.hash()
+
+	connecting := make(chan struct{})
+	c := &apiClient{
+		mux:             &sync.Mutex{},
+		remoteClients:   map[string]*RemoteClient{},
+		activeSessions:  map[string]int{},
+		maxSessions:     2,
+		sessionReleased: map[string]chan struct{}{},
+		connecting:      map[string]chan struct{}{connectionID: connecting},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := c.getRemoteClient(ctx, conn); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected cancellation while waiting for the connection, got %v", err)
+	}
+
+	client := &RemoteClient{done: make(chan struct{})}
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		c.mux.Lock()
+		c.remoteClients[connectionID] = client
+		c.activeSessions[connectionID] = 1
+		c.sessionReleased[connectionID] = make(chan struct{})
+		close(connecting)
+		delete(c.connecting, connectionID)
+		c.mux.Unlock()
+	}()
+	got, err := c.getRemoteClient(context.Background(), conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != client || c.activeSessions[connectionID] != 2 {
+		t.Errorf("expected the connection made while waiting to be used")
+	}
+}
+
+func TestParseResourceID(t *testing.T) {
+	tests := []struct {
+		id      string
+		want    resourceID
+		wantErr bool
+	}{
+		{id: "remotehost:22:/tmp/file.txt", want: resourceID{host: "remotehost", port: 22, path: "/tmp/file.txt"}},
+		{id: "root@remotehost:2222:/tmp/a:b.txt", want: resourceID{user: "root", host: "remotehost", port: 2222, path: "/tmp/a:b.txt"}},
+		{id: "[2001:db8::1]:22:/etc/hosts", want: resourceID{host: "2001:db8::1", port: 22, path: "/etc/hosts"}},
+		{id: "remotehost:22:/tmp/user@host.txt", want: resourceID{host: "remotehost", port: 22, path: "/tmp/user@host.txt"}},
+		{id: "remotehost:/tmp/file.txt", wantErr: true},
+		{id: "remotehost:22:", wantErr: true},
+		{id: "/tmp/file.txt", wantErr: true},
+	}
+
+	for _, test := range tests {
+		got, err := parseResourceID(test.id)
+		if (err != nil) != test.wantErr {
+			t.Errorf("parseResourceID(%q) returned error %v", test.id, err)
+		}
+		if got != test.want {
+			t.Errorf("parseResourceID(%q) = %+v, want %+v", test.id, got, test.want)
+		}
+	}
+}
+
 func testAccPreCheck(t *testing.T) {
 	// You can add code here to run prior to any test case execution, for example assertions
 	// about the appropriate environment variables being set are common to see in a pre-check
 	// function.
 }
+
+func TestMuxServerSchema(t *testing.T) {
+	server, err := newTestServer(New("dev")())
+	if err != nil {
+		t.Fatalf("unable to create mux server: %s", err.Error())
+	}
+
+	resp, err := server.GetProviderSchema(context.Background(), &tfprotov5.GetProviderSchemaRequest{})
+	if err != nil {
+		t.Fatalf("unable to get provider schema: %s", err.Error())
+	}
+	for _, d := range resp.Diagnostics {
+		t.Errorf("%s: %s", d.Summary, d.Detail)
+	}
+
+	for _, name := range []string{"remote_file", "remote_directory", "remote_command"} {
+		if _, ok := resp.ResourceSchemas[name]; !ok {
+			t.Errorf("resource %s is not served", name)
+		}
+	}
+	for _, name := range []string{"remote_file", "remote_command"} {
+		if _, ok := resp.DataSourceSchemas[name]; !ok {
+			t.Errorf("data source %s is not served", name)
+		}
+	}
+}
+
+func TestMuxServerConnectionSchema(t *testing.T) {
+	server, err := newTestServer(New("dev")())
+	if err != nil {
+		t.Fatalf("unable to create mux server: %s", err.Error())
+	}
+
+	resp, err := server.GetProviderSchema(context.Background(), &tfprotov5.GetProviderSchemaRequest{})
+	if err != nil {
+		t.Fatalf("unable to get provider schema: %s", err.Error())
+	}
+
+	// The `conn` blocks of the framework must match those of the SDK.
+	compareConnectionBlocks(t, "conn",
+		nestedBlock(resp.ResourceSchemas["remote_directory"].Block, "conn"),
+		nestedBlock(resp.ResourceSchemas["remote_file"].Block, "conn"))
+	compareConnectionBlocks(t, "conn",
+		nestedBlock(resp.DataSourceSchemas["remote_command"].Block, "conn"),
+		nestedBlock(resp.DataSourceSchemas["remote_file"].Block, "conn"))
+}
+
+func nestedBlock(block *tfprotov5.SchemaBlock, name string) *tfprotov5.SchemaBlock {
+	for _, nested := range block.BlockTypes {
+		if nested.TypeName == name {
+			return nested.Block
+		}
+	}
+	return nil
+}
+
+// compareConnectionBlocks reports differences in the attributes and blocks of
+// want and got. Computed is not compared, as the SDK does not mark attributes
+// with defaults as computed.
+func compareConnectionBlocks(t *testing.T, path string, want *tfprotov5.SchemaBlock, got *tfprotov5.SchemaBlock) {
+	t.Helper()
+
+	if want == nil || got == nil {
+		t.Errorf("%s: missing block", path)
+		return
+	}
+
+	gotAttributes := map[string]*tfprotov5.SchemaAttribute{}
+	for _, attribute := range got.Attributes {
+		gotAttributes[attribute.Name] = attribute
+	}
+	for _, w := range want.Attributes {
+		g, ok := gotAttributes[w.Name]
+		if !ok {
+			t.Errorf("%s.%s: missing attribute", path, w.Name)
+			continue
+		}
+		delete(gotAttributes, w.Name)
+
+		if !g.Type.Equal(w.Type) {
+			t.Errorf("%s.%s: type %s, want %s", path, w.Name, g.Type, w.Type)
+		}
+		if g.Required != w.Required || g.Optional != w.Optional || g.Sensitive != w.Sensitive {
+			t.Errorf("%s.%s: required %t, optional %t, sensitive %t, want %t, %t, %t",
+				path, w.Name, g.Required, g.Optional, g.Sensitive, w.Required, w.Optional, w.Sensitive)
+		}
+		if g.Description != w.Description {
+			t.Errorf("%s.%s: description %q, want %q", path, w.Name, g.Description, w.Description)
+		}
+	}
+	for name := range gotAttributes {
+		t.Errorf("%s.%s: unexpected attribute", path, name)
+	}
+
+	if len(got.BlockTypes) != len(want.BlockTypes) {
+		t.Errorf("%s: %d blocks, want %d", path, len(got.BlockTypes), len(want.BlockTypes))
+	}
+	for _, w := range want.BlockTypes {
+		g := nestedBlock(got, w.TypeName)
+		if g != nil && g.Description != w.Block.Description {
+			t.Errorf("%s.%s: description %q, want %q", path, w.TypeName, g.Description, w.Block.Description)
+		}
+		compareConnectionBlocks(t, path+"."+w.TypeName, w.Block, g)
+	}
+}
@@ -3,10 +3,22 @@ package provider
 import (
 	"bytes"
 	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
+	"net"
 	"os"
+	pathpkg "path"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/bramvdbogaerde/go-scp"
 	"github.com/pkg/sftp"
@@ -24,41 +36,118 @@ func (e Error) Error() string {
 	return fmt.Sprintf("`%s`\n  %s\n  %s", e.cmd, e.err, stderr)
 }
 
-func run(s *ssh.Session, cmd string) error {
-	var buffer bytes.Buffer
-	s.Stderr = &buffer
+func (e Error) Unwrap() error {
+	return e.err
+}
 
-	if err := s.Run(cmd); err != nil {
-		return Error{
-			cmd:    cmd,
-			err:    err,
-			stderr: buffer.Bytes(),
-		}
+// ValidationError is returned when the validation command of a file exits
+// with a non-zero status, rejecting the content of the file.
+type ValidationError struct {
+	err error
+}
+
+func (e ValidationError) Error() string {
+	return e.err.Error()
+}
+
+func (e ValidationError) Unwrap() error {
+	return e.err
+}
+
+// exitStatus returns the exit status of the remote command that caused err,
+// if err is caused by a remote command exiting with a non-zero status.
+func exitStatus(err error) (int, bool) {
+	var exitErr *ssh.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitStatus(), true
 	}
-	return nil
+	return 0, false
 }
 
-func (c *RemoteClient) run(cmd string) error {
-	session, err := c.GetSSHClient().NewSession()
+func (c *RemoteClient) run(ctx context.Context, cmd *shellCommand) error {
+	_, _, err := c.runCommand(ctx, cmd, nil)
+	return err
+}
+
+// runCommand runs cmd with stdin, and returns its stdout and stderr. The
+// password used to become another user is written to stdin before stdin,
+// when needed.
+func (c *RemoteClient) runCommand(ctx context.Context, cmd *shellCommand, stdin io.Reader) ([]byte, []byte, error) {
+	session, err := c.newSession(ctx)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 	defer session.Close()
 
-	return run(session, cmd)
+	var stdout, stderr bytes.Buffer
+	session.Stdout = &stdout
+	session.Stderr = &stderr
+	session.Stdin = cmd.stdin(stdin)
+
+	if err := session.Run(cmd.String()); err != nil {
+		return stdout.Bytes(), stderr.Bytes(), Error{
+			cmd:    cmd.String(),
+			err:    err,
+			stderr: stderr.Bytes(),
+		}
+	}
+	return stdout.Bytes(), stderr.Bytes(), nil
+}
+
+// RunCommand runs cmd in a shell on the remote host, and returns its stdout
+// and stderr.
+func (c *RemoteClient) RunCommand(ctx context.Context, cmd string, sudo bool) (string, string, error) {
+	stdout, stderr, err := c.runCommand(ctx, shellScript(cmd).withBecome(c.becomeIf(sudo)), nil)
+	return string(stdout), string(stderr), err
+}
+
+// newSession opens a session on the remote host, retrying when the remote host
+// refuses to open it.
+func (c *RemoteClient) newSession(ctx context.Context) (*ssh.Session, error) {
+	var session *ssh.Session
+	err := c.retry.do(ctx, isSessionRefused, func() (err error) {
+		session, err = c.sshClient.NewSession()
+		return err
+	})
+	return session, err
+}
+
+// becomeIf returns how to become another user when sudo is true, otherwise
+// nil.
+func (c *RemoteClient) becomeIf(sudo bool) *become {
+	if sudo {
+		return c.become
+	}
+	return nil
 }
 
 type RemoteClient struct {
 	sshClient *ssh.Client
+	hostKey   ssh.PublicKey
+	// How to become another user in shell commands run with sudo.
+	become *become
+	// Path to the SFTP server started with sudo, to access files over SFTP
+	// instead of shell commands when using sudo.
+	sftpServerPath string
+	// SFTP clients shared by all operations, keyed by whether they run as the
+	// user to become.
+	sftpMux     sync.Mutex
+	sftpClients map[bool]*sftp.Client
+	// Closed when the connection to the remote host is closed or lost.
+	done chan struct{}
+	// How to retry opening sessions refused by the remote host.
+	retry *retryPolicy
+	// The SSH agent used to login to the remote host, if any.
+	agent *sshAgent
 }
 
 func (c *RemoteClient) WriteFile(
 	ctx context.Context, content string, path string, permissions string, sudo bool,
 ) error {
-	if sudo {
-		return c.WriteFileShell(content, path, permissions)
+	if c.useShell(sudo) {
+		return c.WriteFileShell(ctx, content, path, permissions)
 	}
-	return c.WriteFileSFTP(ctx, content, path, permissions)
+	return c.WriteFileSFTP(ctx, content, path, permissions, sudo)
 }
 
 func (c *RemoteClient) WriteFileSCP(ctx context.Context, content string, path string, permissions string) error {
@@ -71,16 +160,15 @@ func (c *RemoteClient) WriteFileSCP(ctx context.Context, content string, path st
 	return scpClient.CopyFile(ctx, strings.NewReader(content), path, permissions)
 }
 
-func (c *RemoteClient) WriteFileSFTP(_ context.Context, content string, path string, permissions string) error {
+func (c *RemoteClient) WriteFileSFTP(ctx context.Context, content string, path string, permissions string, sudo bool) error {
 	perm, err := strconv.ParseUint(permissions, 8, 32)
 	if err != nil {
 		return err
 	}
-	sftpClient, err := c.GetSFTPClient()
+	sftpClient, err := c.getSFTPClient(ctx, sudo)
 	if err != nil {
 		return err
 	}
-	defer sftpClient.Close()
 
 	file, err := sftpClient.Create(path)
 	if err != nil {
@@ -100,100 +188,337 @@ func (c *RemoteClient) WriteFileSFTP(_ context.Context, content string, path str
 	return nil
 }
 
-func (c *RemoteClient) WriteFileShell(content string, path string, permissions string) error {
-	sshClient := c.GetSSHClient()
+func (c *RemoteClient) WriteFileShell(ctx context.Context, content string, path string, permissions string) error {
+	cmd := newShellCommand("touch").operands(path).withBecome(c.become)
+	err := c.run(ctx, cmd)
+	if err != nil {
+		return err
+	}
 
-	session, err := sshClient.NewSession()
+	cmd = newShellCommand("chmod").operands(permissions, path).withBecome(c.become)
+	err = c.run(ctx, cmd)
 	if err != nil {
 		return err
 	}
-	defer session.Close()
 
-	cmd := fmt.Sprintf("sudo touch %s", path)
-	err = c.run(cmd)
+	cmd = shellScript(`cat > "$1"`, path).withBecome(c.become)
+	_, _, err = c.runCommand(ctx, cmd, strings.NewReader(content))
+	return err
+}
+
+// WriteFileAtomic writes content to a temporary file next to path, which is
+// renamed over path once its permissions and ownership are set, it passes
+// validation and its content is flushed to disk. Readers of path see either
+// the previous or the new content, never a partially written file.
+func (c *RemoteClient) WriteFileAtomic(
+	ctx context.Context, content string, path string, permissions string, owner string, group string,
+	validateCommand string, sudo bool,
+) error {
+	tmpPath, err := c.StageFile(ctx, content, path, permissions, owner, group, sudo)
 	if err != nil {
 		return err
 	}
 
-	cmd = fmt.Sprintf("sudo chmod %s %s", permissions, path)
-	err = c.run(cmd)
+	err = func() error {
+		if validateCommand != "" {
+			if err := c.ValidateFile(ctx, validateCommand, tmpPath, sudo); err != nil {
+				return err
+			}
+		}
+		if err := c.SyncFile(ctx, tmpPath, sudo); err != nil {
+			return err
+		}
+		return c.RenameFile(ctx, tmpPath, path, sudo)
+	}()
 	if err != nil {
+		_ = c.DeleteFile(ctx, tmpPath, sudo)
 		return err
 	}
 
-	stdin, err := session.StdinPipe()
+	return nil
+}
+
+// ValidateContent stages content in a temporary file next to path, which is
+// validated and removed without touching path.
+func (c *RemoteClient) ValidateContent(
+	ctx context.Context, content string, path string, permissions string, owner string, group string,
+	validateCommand string, sudo bool,
+) error {
+	tmpPath, err := c.StageFile(ctx, content, path, permissions, owner, group, sudo)
 	if err != nil {
 		return err
 	}
 
-	go func() {
-		_, _ = stdin.Write([]byte(content))
-		stdin.Close()
+	if err := c.ValidateFile(ctx, validateCommand, tmpPath, sudo); err != nil {
+		_ = c.DeleteFile(ctx, tmpPath, sudo)
+		return err
+	}
+	return c.DeleteFile(ctx, tmpPath, sudo)
+}
+
+// StageFile writes content to a temporary file next to path, with the
+// permissions and ownership of path, and returns the path to the temporary
+// file. The owner and group that are not set are those of path, if it exists,
+// as renaming the temporary file over path would otherwise change them.
+func (c *RemoteClient) StageFile(
+	ctx context.Context, content string, path string, permissions string, owner string, group string, sudo bool,
+) (string, error) {
+	tmpPath, err := temporaryPath(path)
+	if err != nil {
+		return "", err
+	}
+
+	if owner == "" || group == "" {
+		info, err := c.Stat(ctx, path, sudo)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return "", err
+		}
+		if err == nil {
+			if owner == "" {
+				owner = strconv.Itoa(info.UID)
+			}
+			if group == "" {
+				group = strconv.Itoa(info.GID)
+			}
+		}
+	}
+
+	err = func() error {
+		if err := c.WriteFile(ctx, content, tmpPath, permissions, sudo); err != nil {
+			return err
+		}
+		if group != "" {
+			if err := c.ChgrpFile(ctx, tmpPath, group, sudo); err != nil {
+				return err
+			}
+		}
+		if owner != "" {
+			if err := c.ChownFile(ctx, tmpPath, owner, sudo); err != nil {
+				return err
+			}
+		}
+		return nil
 	}()
+	if err != nil {
+		// Best effort removal, the temporary file may not have been created.
+		_ = c.DeleteFile(ctx, tmpPath, sudo)
+		return "", err
+	}
 
-	cmd = fmt.Sprintf("cat /dev/stdin | sudo tee %s", path)
-	return run(session, cmd)
+	return tmpPath, nil
 }
 
-func (c *RemoteClient) ChmodFile(path string, permissions string, sudo bool) error {
-	if sudo {
-		return c.ChmodFileShell(path, permissions, sudo)
+// ValidateFile runs the validation command in a shell, with `%s` replaced by
+// the quoted path. A ValidationError wrapping the Error with the stderr of the
+// command is returned when validation fails.
+func (c *RemoteClient) ValidateFile(ctx context.Context, validateCommand string, path string, sudo bool) error {
+	script := strings.ReplaceAll(validateCommand, "%s", quote(path))
+	err := c.run(ctx, shellScript(script).withBecome(c.becomeIf(sudo)))
+	if _, ok := exitStatus(err); ok {
+		return ValidationError{err: err}
+	}
+	return err
+}
+
+// temporaryPath returns a random hidden path in the same directory as name,
+// so that it can be renamed to name within the same file system.
+func temporaryPath(name string) (string, error) {
+	random := make([]byte, 6)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
 	}
-	return c.ChmodFileSFTP(path, permissions)
+	base := fmt.Sprintf(".%s.%s.tmp", pathpkg.Base(name), hex.EncodeToString(random))
+	return pathpkg.Join(pathpkg.Dir(name), base), nil
 }
 
-func (c *RemoteClient) ChmodFileSFTP(path string, permissions string) error {
-	sftpClient, err := c.GetSFTPClient()
+func (c *RemoteClient) SyncFile(ctx context.Context, path string, sudo bool) error {
+	if c.useShell(sudo) {
+		return c.SyncFileShell(ctx, path)
+	}
+	return c.SyncFileSFTP(ctx, path, sudo)
+}
+
+func (c *RemoteClient) SyncFileSFTP(ctx context.Context, path string, sudo bool) error {
+	sftpClient, err := c.getSFTPClient(ctx, sudo)
 	if err != nil {
 		return err
 	}
-	defer sftpClient.Close()
 
-	perm, err := strconv.ParseUint(permissions, 8, 32)
+	file, err := sftpClient.OpenFile(path, os.O_WRONLY)
 	if err != nil {
 		return err
 	}
-	return sftpClient.Chmod(path, os.FileMode(perm))
+	defer file.Close()
+
+	// Servers without the fsync extension flush the file on their own terms.
+	err = file.Sync()
+	var statusErr *sftp.StatusError
+	if errors.As(err, &statusErr) && statusErr.FxCode() == sftp.ErrSSHFxOpUnsupported {
+		return nil
+	}
+	return err
 }
 
-func (c *RemoteClient) ChmodFileShell(path string, permissions string, sudo bool) error {
-	cmd := fmt.Sprintf("chmod %s %s", permissions, path)
-	if sudo {
-		cmd = fmt.Sprintf("sudo %s", cmd)
+func (c *RemoteClient) SyncFileShell(ctx context.Context, path string) error {
+	cmd := newShellCommand("sync").operands(path).withBecome(c.become)
+	return c.run(ctx, cmd)
+}
+
+func (c *RemoteClient) RenameFile(ctx context.Context, oldPath string, newPath string, sudo bool) error {
+	if c.useShell(sudo) {
+		return c.RenameFileShell(ctx, oldPath, newPath)
 	}
-	return c.run(cmd)
+	return c.RenameFileSFTP(ctx, oldPath, newPath, sudo)
 }
 
-func (c *RemoteClient) ChgrpFile(path string, group string, sudo bool) error {
-	cmd := fmt.Sprintf("chgrp %s %s", group, path)
-	if sudo {
-		cmd = fmt.Sprintf("sudo %s", cmd)
+func (c *RemoteClient) RenameFileSFTP(ctx context.Context, oldPath string, newPath string, sudo bool) error {
+	sftpClient, err := c.getSFTPClient(ctx, sudo)
+	if err != nil {
+		return err
 	}
 
-	return c.run(cmd)
+	return sftpClient.PosixRename(oldPath, newPath)
 }
 
-func (c *RemoteClient) ChownFile(path string, owner string, sudo bool) error {
-	cmd := fmt.Sprintf("chown %s %s", owner, path)
-	if sudo {
-		cmd = fmt.Sprintf("sudo %s", cmd)
+func (c *RemoteClient) RenameFileShell(ctx context.Context, oldPath string, newPath string) error {
+	cmd := newShellCommand("mv", "-f").operands(oldPath, newPath).withBecome(c.become)
+	return c.run(ctx, cmd)
+}
+
+func (c *RemoteClient) CopyFile(ctx context.Context, srcPath string, dstPath string, sudo bool) error {
+	if c.useShell(sudo) {
+		return c.CopyFileShell(ctx, srcPath, dstPath)
 	}
-	return c.run(cmd)
+	return c.CopyFileSFTP(ctx, srcPath, dstPath, sudo)
 }
 
-func (c *RemoteClient) FileExists(path string, sudo bool) (bool, error) {
-	if sudo {
-		return c.FileExistsShell(path, sudo)
+// CopyFileSFTP copies the content and permissions of a file. The content
+// passes through the local host, as SFTP has no way to copy remote files.
+func (c *RemoteClient) CopyFileSFTP(ctx context.Context, srcPath string, dstPath string, sudo bool) error {
+	sftpClient, err := c.getSFTPClient(ctx, sudo)
+	if err != nil {
+		return err
+	}
+
+	src, err := sftpClient.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	stat, err := src.Stat()
+	if err != nil {
+		return err
+	}
+
+	dst, err := sftpClient.Create(dstPath)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	if err := sftpClient.Chmod(dstPath, stat.Mode()); err != nil {
+		return err
+	}
+
+	_, err = io.Copy(dst, src)
+	return err
+}
+
+func (c *RemoteClient) CopyFileShell(ctx context.Context, srcPath string, dstPath string) error {
+	cmd := newShellCommand("cp", "-p").operands(srcPath, dstPath).withBecome(c.become)
+	return c.run(ctx, cmd)
+}
+
+// ReadDirectory returns the names of the entries in a directory.
+func (c *RemoteClient) ReadDirectory(ctx context.Context, path string, sudo bool) ([]string, error) {
+	if c.useShell(sudo) {
+		return c.ReadDirectoryShell(ctx, path)
+	}
+	return c.ReadDirectorySFTP(ctx, path, sudo)
+}
+
+func (c *RemoteClient) ReadDirectorySFTP(ctx context.Context, path string, sudo bool) ([]string, error) {
+	sftpClient, err := c.getSFTPClient(ctx, sudo)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := sftpClient.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names, nil
+}
+
+func (c *RemoteClient) ReadDirectoryShell(ctx context.Context, path string) ([]string, error) {
+	cmd := newShellCommand("ls", "-1A").operands(path).withBecome(c.become)
+	output, _, err := c.runCommand(ctx, cmd, nil)
+	if err != nil {
+		return nil, err
 	}
-	return c.FileExistsSFTP(path)
+
+	names := []string{}
+	for _, name := range strings.Split(string(output), "\n") {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+func (c *RemoteClient) ChmodFile(ctx context.Context, path string, permissions string, sudo bool) error {
+	if c.useShell(sudo) {
+		return c.ChmodFileShell(ctx, path, permissions, sudo)
+	}
+	return c.ChmodFileSFTP(ctx, path, permissions, sudo)
+}
+
+func (c *RemoteClient) ChmodFileSFTP(ctx context.Context, path string, permissions string, sudo bool) error {
+	sftpClient, err := c.getSFTPClient(ctx, sudo)
+	if err != nil {
+		return err
+	}
+
+	perm, err := strconv.ParseUint(permissions, 8, 32)
+	if err != nil {
+		return err
+	}
+	return sftpClient.Chmod(path, os.FileMode(perm))
+}
+
+func (c *RemoteClient) ChmodFileShell(ctx context.Context, path string, permissions string, sudo bool) error {
+	cmd := newShellCommand("chmod").operands(permissions, path).withBecome(c.becomeIf(sudo))
+	return c.run(ctx, cmd)
+}
+
+func (c *RemoteClient) ChgrpFile(ctx context.Context, path string, group string, sudo bool) error {
+	cmd := newShellCommand("chgrp").operands(group, path).withBecome(c.becomeIf(sudo))
+	return c.run(ctx, cmd)
+}
+
+func (c *RemoteClient) ChownFile(ctx context.Context, path string, owner string, sudo bool) error {
+	cmd := newShellCommand("chown").operands(owner, path).withBecome(c.becomeIf(sudo))
+	return c.run(ctx, cmd)
+}
+
+func (c *RemoteClient) FileExists(ctx context.Context, path string, sudo bool) (bool, error) {
+	if c.useShell(sudo) {
+		return c.FileExistsShell(ctx, path, sudo)
+	}
+	return c.FileExistsSFTP(ctx, path, sudo)
 }
 
-func (c *RemoteClient) FileExistsSFTP(path string) (bool, error) {
-	sftpClient, err := c.GetSFTPClient()
+func (c *RemoteClient) FileExistsSFTP(ctx context.Context, path string, sudo bool) (bool, error) {
+	sftpClient, err := c.getSFTPClient(ctx, sudo)
 	if err != nil {
 		return false, err
 	}
-	defer sftpClient.Close()
 
 	_, err = sftpClient.Stat(path)
 	if err == nil {
@@ -205,36 +530,28 @@ func (c *RemoteClient) FileExistsSFTP(path string) (bool, error) {
 	return false, err
 }
 
-func (c *RemoteClient) FileExistsShell(path string, sudo bool) (bool, error) {
-	cmd := fmt.Sprintf("test -f %s", path)
-	if sudo {
-		cmd = fmt.Sprintf("sudo %s", cmd)
-	}
-
-	if err := c.run(cmd); err != nil {
-		cmd := fmt.Sprintf("test ! -f %s", path)
-		if sudo {
-			cmd = fmt.Sprintf("sudo %s", cmd)
-		}
-		return false, c.run(cmd)
+func (c *RemoteClient) FileExistsShell(ctx context.Context, path string, sudo bool) (bool, error) {
+	cmd := newShellCommand("test", "-f").arguments(path).withBecome(c.becomeIf(sudo))
+	if err := c.run(ctx, cmd); err != nil {
+		cmd := newShellCommand("test", "!", "-f").arguments(path).withBecome(c.becomeIf(sudo))
+		return false, c.run(ctx, cmd)
 	}
 
 	return true, nil
 }
 
-func (c *RemoteClient) ReadFile(path string, sudo bool) (string, error) {
-	if sudo {
-		return c.ReadFileShell(path)
+func (c *RemoteClient) ReadFile(ctx context.Context, path string, sudo bool) (string, error) {
+	if c.useShell(sudo) {
+		return c.ReadFileShell(ctx, path)
 	}
-	return c.ReadFileSFTP(path)
+	return c.ReadFileSFTP(ctx, path, sudo)
 }
 
-func (c *RemoteClient) ReadFileSFTP(path string) (string, error) {
-	sftpClient, err := c.GetSFTPClient()
+func (c *RemoteClient) ReadFileSFTP(ctx context.Context, path string, sudo bool) (string, error) {
+	sftpClient, err := c.getSFTPClient(ctx, sudo)
 	if err != nil {
 		return "", err
 	}
-	defer sftpClient.Close()
 
 	file, err := sftpClient.Open(path)
 	if err != nil {
@@ -250,143 +567,452 @@ func (c *RemoteClient) ReadFileSFTP(path string) (string, error) {
 	return content.String(), nil
 }
 
-func (c *RemoteClient) ReadFileShell(path string) (string, error) {
-	sshClient := c.GetSSHClient()
-
-	session, err := sshClient.NewSession()
+func (c *RemoteClient) ReadFileShell(ctx context.Context, path string) (string, error) {
+	cmd := newShellCommand("cat").operands(path).withBecome(c.become)
+	content, _, err := c.runCommand(ctx, cmd, nil)
 	if err != nil {
 		return "", err
 	}
-	defer session.Close()
 
-	cmd := fmt.Sprintf("sudo cat %s", path)
-	content, err := session.Output(cmd)
+	return string(content), nil
+}
+
+// FileHashes contains the hex encoded hashes and the size of the content of a
+// file.
+type FileHashes struct {
+	SHA256 string
+	SHA1   string
+	MD5    string
+	Size   int64
+}
+
+func hashContent(content io.Reader) (FileHashes, error) {
+	sha256Hash, sha1Hash, md5Hash := sha256.New(), sha1.New(), md5.New()
+	size, err := io.Copy(io.MultiWriter(sha256Hash, sha1Hash, md5Hash), content)
 	if err != nil {
-		return "", err
+		return FileHashes{}, err
 	}
 
-	return string(content), nil
+	return FileHashes{
+		SHA256: hex.EncodeToString(sha256Hash.Sum(nil)),
+		SHA1:   hex.EncodeToString(sha1Hash.Sum(nil)),
+		MD5:    hex.EncodeToString(md5Hash.Sum(nil)),
+		Size:   size,
+	}, nil
 }
 
-func (c *RemoteClient) ReadFilePermissions(path string, sudo bool) (string, error) {
-	if sudo {
-		return c.ReadFilePermissionsShell(path, sudo)
+// ReadFileHashes hashes the content of the file on the remote host, without
+// transferring the content.
+func (c *RemoteClient) ReadFileHashes(ctx context.Context, path string, sudo bool) (FileHashes, error) {
+	if c.useShell(sudo) {
+		return c.ReadFileHashesShell(ctx, path)
 	}
-	return c.ReadFilePermissionsSFTP(path)
+	return c.ReadFileHashesSFTP(ctx, path, sudo)
 }
 
-func (c *RemoteClient) ReadFilePermissionsSFTP(path string) (string, error) {
-	sftpClient, err := c.GetSFTPClient()
+func (c *RemoteClient) ReadFileHashesSFTP(ctx context.Context, path string, sudo bool) (FileHashes, error) {
+	sftpClient, err := c.getSFTPClient(ctx, sudo)
 	if err != nil {
-		return "", err
+		return FileHashes{}, err
 	}
-	defer sftpClient.Close()
 
-	stat, err := sftpClient.Stat(path)
+	file, err := sftpClient.Open(path)
 	if err != nil {
-		return "", nil
+		return FileHashes{}, err
 	}
-	return fmt.Sprintf("%04o", stat.Mode()), err
+	defer file.Close()
+
+	return hashContent(file)
 }
 
-func (c *RemoteClient) ReadFilePermissionsShell(path string, sudo bool) (string, error) {
-	sshClient := c.GetSSHClient()
+func (c *RemoteClient) ReadFileHashesShell(ctx context.Context, path string) (FileHashes, error) {
+	script := `sha256sum -- "$1" && sha1sum -- "$1" && md5sum -- "$1" && stat -c %s -- "$1"`
+	cmd := shellScript(script, path).withBecome(c.become)
+	output, _, err := c.runCommand(ctx, cmd, nil)
+	if err != nil {
+		return FileHashes{}, err
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	if len(lines) != 4 {
+		return FileHashes{}, fmt.Errorf("unexpected output from `%s`: %s", cmd, output)
+	}
 
-	session, err := sshClient.NewSession()
+	// The hash is prefixed with a backslash when the file name contains
+	// characters that are escaped in the output.
+	hash := func(line string) string {
+		hash, _, _ := strings.Cut(line, " ")
+		return strings.TrimPrefix(hash, "\\")
+	}
+
+	size, err := strconv.ParseInt(lines[3], 10, 64)
 	if err != nil {
-		return "", err
+		return FileHashes{}, err
 	}
-	defer session.Close()
 
-	cmd := fmt.Sprintf("stat -c %%a %s", path)
-	if sudo {
-		cmd = fmt.Sprintf("sudo %s", cmd)
+	return FileHashes{
+		SHA256: hash(lines[0]),
+		SHA1:   hash(lines[1]),
+		MD5:    hash(lines[2]),
+		Size:   size,
+	}, nil
+}
+
+// formatPermissions formats the permission bits of mode in octal form, as
+// presented by `stat -c %a`. Go keeps the type and special bits of a mode
+// outside of the lower twelve bits.
+func formatPermissions(mode os.FileMode) string {
+	permissions := mode.Perm()
+	if mode&os.ModeSetuid != 0 {
+		permissions |= 04000
+	}
+	if mode&os.ModeSetgid != 0 {
+		permissions |= 02000
+	}
+	if mode&os.ModeSticky != 0 {
+		permissions |= 01000
 	}
-	output, err := session.Output(cmd)
+	return fmt.Sprintf("%04o", uint32(permissions))
+}
+
+// FileInfo contains the metadata of a file.
+type FileInfo struct {
+	// The type and permission bits of the file.
+	Mode      os.FileMode
+	UID       int
+	GID       int
+	UserName  string
+	GroupName string
+	Size      int64
+	ModTime   time.Time
+}
+
+// Permissions returns the permissions of the file in octal form.
+func (i FileInfo) Permissions() string {
+	return formatPermissions(i.Mode)
+}
+
+// Stat returns the metadata of the file at path, following symbolic links.
+// The returned error wraps fs.ErrNotExist when the file does not exist.
+//
+// Unlike most file operations, Stat always runs stat on the remote host, as
+// SFTP does not provide the user and group names of the file and would need
+// a second round trip to resolve them.
+func (c *RemoteClient) Stat(ctx context.Context, path string, sudo bool) (FileInfo, error) {
+	// Fields are separated by colons, which user and group names can not
+	// contain as /etc/passwd and /etc/group are colon separated.
+	cmd := newShellCommand("stat", "-L", "-c", "%f:%u:%g:%s:%Y:%U:%G").operands(path).withBecome(c.becomeIf(sudo))
+	output, _, err := c.runCommand(ctx, cmd, nil)
 	if err != nil {
-		return "", err
+		return FileInfo{}, statError(err)
 	}
 
-	permissions := strings.ReplaceAll(string(output), "\n", "")
-	if len(permissions) > 0 && len(permissions) < 4 {
-		permissions = fmt.Sprintf("0%s", permissions)
+	fields := strings.SplitN(strings.TrimSpace(string(output)), ":", 7)
+	if len(fields) != 7 {
+		return FileInfo{}, fmt.Errorf("unexpected output from `%s`: %s", cmd, output)
+	}
+
+	mode, err := strconv.ParseUint(fields[0], 16, 32)
+	if err != nil {
+		return FileInfo{}, err
 	}
-	return permissions, nil
+	uid, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return FileInfo{}, err
+	}
+	gid, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return FileInfo{}, err
+	}
+	size, err := strconv.ParseInt(fields[3], 10, 64)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	modTime, err := strconv.ParseInt(fields[4], 10, 64)
+	if err != nil {
+		return FileInfo{}, err
+	}
+
+	return FileInfo{
+		Mode:      unixFileMode(uint32(mode)),
+		UID:       uid,
+		GID:       gid,
+		UserName:  fields[5],
+		GroupName: fields[6],
+		Size:      size,
+		ModTime:   time.Unix(modTime, 0),
+	}, nil
 }
 
-func (c *RemoteClient) ReadFileOwner(path string, sudo bool) (string, error) {
-	return c.StatFile(path, "u", sudo)
+// statError wraps fs.ErrNotExist in err when stat failed as the file does
+// not exist.
+func statError(err error) error {
+	var e Error
+	if errors.As(err, &e) && strings.Contains(string(e.stderr), "No such file or directory") {
+		return fmt.Errorf("%w: %s", fs.ErrNotExist, err.Error())
+	}
+	return err
+}
+
+// unixFileMode converts the st_mode of a file to os.FileMode.
+func unixFileMode(mode uint32) os.FileMode {
+	fileMode := os.FileMode(mode & 0777)
+	if mode&04000 != 0 {
+		fileMode |= os.ModeSetuid
+	}
+	if mode&02000 != 0 {
+		fileMode |= os.ModeSetgid
+	}
+	if mode&01000 != 0 {
+		fileMode |= os.ModeSticky
+	}
+
+	switch mode & 0170000 {
+	case 0040000:
+		fileMode |= os.ModeDir
+	case 0120000:
+		fileMode |= os.ModeSymlink
+	case 0020000:
+		fileMode |= os.ModeDevice | os.ModeCharDevice
+	case 0060000:
+		fileMode |= os.ModeDevice
+	case 0010000:
+		fileMode |= os.ModeNamedPipe
+	case 0140000:
+		fileMode |= os.ModeSocket
+	}
+	return fileMode
 }
 
-func (c *RemoteClient) ReadFileGroup(path string, sudo bool) (string, error) {
-	return c.StatFile(path, "g", sudo)
+func (c *RemoteClient) DeleteFile(ctx context.Context, path string, sudo bool) error {
+	if c.useShell(sudo) {
+		return c.DeleteFileShell(ctx, path)
+	}
+	return c.DeleteFileSFTP(ctx, path, sudo)
 }
 
-func (c *RemoteClient) ReadFileOwnerName(path string, sudo bool) (string, error) {
-	return c.StatFile(path, "U", sudo)
+func (c *RemoteClient) DeleteFileSFTP(ctx context.Context, path string, sudo bool) error {
+	sftpClient, err := c.getSFTPClient(ctx, sudo)
+	if err != nil {
+		return err
+	}
+
+	return sftpClient.Remove(path)
 }
 
-func (c *RemoteClient) ReadFileGroupName(path string, sudo bool) (string, error) {
-	return c.StatFile(path, "G", sudo)
+func (c *RemoteClient) DeleteFileShell(ctx context.Context, path string) error {
+	cmd := newShellCommand("rm").operands(path).withBecome(c.become)
+	return c.run(ctx, cmd)
 }
 
-func (c *RemoteClient) StatFile(path string, char string, sudo bool) (string, error) {
-	sshClient := c.GetSSHClient()
+func (c *RemoteClient) CreateDirectory(ctx context.Context, path string, recursive bool, sudo bool) error {
+	if c.useShell(sudo) {
+		return c.CreateDirectoryShell(ctx, path, recursive, sudo)
+	}
+	return c.CreateDirectorySFTP(ctx, path, recursive, sudo)
+}
 
-	session, err := sshClient.NewSession()
+func (c *RemoteClient) CreateDirectorySFTP(ctx context.Context, path string, recursive bool, sudo bool) error {
+	sftpClient, err := c.getSFTPClient(ctx, sudo)
 	if err != nil {
-		return "", err
+		return err
 	}
-	defer session.Close()
 
-	cmd := fmt.Sprintf("stat -c %%%s %s", char, path)
-	if sudo {
-		cmd = fmt.Sprintf("sudo %s", cmd)
+	if recursive {
+		return sftpClient.MkdirAll(path)
 	}
-	output, err := session.Output(cmd)
+	return sftpClient.Mkdir(path)
+}
+
+func (c *RemoteClient) CreateDirectoryShell(ctx context.Context, path string, recursive bool, sudo bool) error {
+	cmd := newShellCommand("mkdir")
+	if recursive {
+		cmd = newShellCommand("mkdir", "-p")
+	}
+	return c.run(ctx, cmd.operands(path).withBecome(c.becomeIf(sudo)))
+}
+
+func (c *RemoteClient) DirectoryExists(ctx context.Context, path string, sudo bool) (bool, error) {
+	if c.useShell(sudo) {
+		return c.DirectoryExistsShell(ctx, path, sudo)
+	}
+	return c.DirectoryExistsSFTP(ctx, path, sudo)
+}
+
+func (c *RemoteClient) DirectoryExistsSFTP(ctx context.Context, path string, sudo bool) (bool, error) {
+	sftpClient, err := c.getSFTPClient(ctx, sudo)
 	if err != nil {
-		return "", err
+		return false, err
 	}
 
-	group := strings.ReplaceAll(string(output), "\n", "")
-	return group, nil
+	stat, err := sftpClient.Stat(path)
+	if err == nil {
+		return stat.IsDir(), nil
+	}
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return false, err
 }
 
-func (c *RemoteClient) DeleteFile(path string, sudo bool) error {
-	if sudo {
-		return c.DeleteFileShell(path)
+func (c *RemoteClient) DirectoryExistsShell(ctx context.Context, path string, sudo bool) (bool, error) {
+	cmd := newShellCommand("test", "-d").arguments(path).withBecome(c.becomeIf(sudo))
+	if err := c.run(ctx, cmd); err != nil {
+		cmd := newShellCommand("test", "!", "-d").arguments(path).withBecome(c.becomeIf(sudo))
+		return false, c.run(ctx, cmd)
 	}
-	return c.DeleteFileSFTP(path)
+
+	return true, nil
 }
 
-func (c *RemoteClient) DeleteFileSFTP(path string) error {
-	sftpClient, err := c.GetSFTPClient()
+func (c *RemoteClient) DeleteDirectory(ctx context.Context, path string, force bool, sudo bool) error {
+	if c.useShell(sudo) {
+		return c.DeleteDirectoryShell(ctx, path, force)
+	}
+	return c.DeleteDirectorySFTP(ctx, path, force, sudo)
+}
+
+func (c *RemoteClient) DeleteDirectorySFTP(ctx context.Context, path string, force bool, sudo bool) error {
+	sftpClient, err := c.getSFTPClient(ctx, sudo)
 	if err != nil {
 		return err
 	}
-	defer sftpClient.Close()
 
-	return sftpClient.Remove(path)
+	if force {
+		return sftpClient.RemoveAll(path)
+	}
+	return sftpClient.RemoveDirectory(path)
 }
 
-func (c *RemoteClient) DeleteFileShell(path string) error {
-	cmd := fmt.Sprintf("sudo rm %s", path)
-	return c.run(cmd)
+func (c *RemoteClient) DeleteDirectoryShell(ctx context.Context, path string, force bool) error {
+	cmd := newShellCommand("rmdir")
+	if force {
+		cmd = newShellCommand("rm", "-rf")
+	}
+	return c.run(ctx, cmd.operands(path).withBecome(c.become))
 }
 
-func NewRemoteClient(host string, clientConfig *ssh.ClientConfig) (*RemoteClient, error) {
-	client, err := ssh.Dial("tcp", host, clientConfig)
+// NewRemoteClient connects to host, through the jump host connection unless
+// it is nil.
+func NewRemoteClient(jump *ssh.Client, host string, clientConfig *ssh.ClientConfig) (*RemoteClient, error) {
+	var hostKey ssh.PublicKey
+	config := *clientConfig
+	config.HostKeyCallback = func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		hostKey = key
+		return clientConfig.HostKeyCallback(hostname, remote, key)
+	}
+
+	client, err := dialSSH(jump, host, &config)
 	if err != nil {
-		return nil, fmt.Errorf("couldn't establish a connection to the remote server '%s@%s': %s", clientConfig.User, host, err.Error())
+		return nil, fmt.Errorf("couldn't establish a connection to the remote server '%s@%s': %w", clientConfig.User, host, err)
 	}
 
 	return &RemoteClient{
-		sshClient: client,
+		sshClient:   client,
+		hostKey:     hostKey,
+		become:      &become{method: "sudo"},
+		sftpClients: map[bool]*sftp.Client{},
+		done:        watchClosed(client),
 	}, nil
 }
 
+// watchClosed returns a channel that is closed once the connection of client
+// is closed.
+func watchClosed(client *ssh.Client) chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		_ = client.Wait()
+		close(done)
+	}()
+	return done
+}
+
+// alive reports whether the connection to the remote host is still open.
+func (c *RemoteClient) alive() bool {
+	select {
+	case <-c.done:
+		return false
+	default:
+		return true
+	}
+}
+
+// keepAlive sends a keepalive request to the remote host at every interval,
+// and closes the connection when the remote host does not reply within the
+// interval, such that a lost connection is noticed while it is idle.
+func (c *RemoteClient) keepAlive(interval time.Duration) {
+	sendKeepalives(c.sshClient, c.done, interval)
+}
+
+// sendKeepalives sends a keepalive request over client at every interval
+// until done is closed, and closes client when no reply is received within
+// the interval.
+func sendKeepalives(client *ssh.Client, done <-chan struct{}, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+
+			replied := make(chan error, 1)
+			go func() {
+				_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+				replied <- err
+			}()
+
+			timer := time.NewTimer(interval)
+			select {
+			case err := <-replied:
+				if err != nil {
+					_ = client.Close()
+				}
+			case <-timer.C:
+				_ = client.Close()
+			case <-done:
+			}
+			timer.Stop()
+		}
+	}()
+}
+
+func dialSSH(jump *ssh.Client, host string, clientConfig *ssh.ClientConfig) (*ssh.Client, error) {
+	if jump == nil {
+		return ssh.Dial("tcp", host, clientConfig)
+	}
+
+	conn, err := jump.Dial("tcp", host)
+	if err != nil {
+		return nil, err
+	}
+
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, host, clientConfig)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return ssh.NewClient(sshConn, chans, reqs), nil
+}
+
 func (c *RemoteClient) Close() error {
-	return c.sshClient.Close()
+	c.sftpMux.Lock()
+	var errs []error
+	for sudo, client := range c.sftpClients {
+		errs = append(errs, client.Close())
+		delete(c.sftpClients, sudo)
+	}
+	c.sftpMux.Unlock()
+
+	return errors.Join(append(errs, c.sshClient.Close(), c.agent.Close())...)
+}
+
+// HostKey returns the public key presented by the remote host.
+func (c *RemoteClient) HostKey() ssh.PublicKey {
+	return c.hostKey
 }
 
 func (c *RemoteClient) GetSSHClient() *ssh.Client {
@@ -397,6 +1023,119 @@ func (c *RemoteClient) GetSCPClient() (scp.Client, error) {
 	return scp.NewClientBySSH(c.sshClient)
 }
 
-func (c *RemoteClient) GetSFTPClient() (*sftp.Client, error) {
-	return sftp.NewClient(c.sshClient)
+// GetSFTPClient returns the SFTP client shared by all operations on the
+// remote host, which must not be closed.
+func (c *RemoteClient) GetSFTPClient(ctx context.Context) (*sftp.Client, error) {
+	return c.getSFTPClient(ctx, false)
+}
+
+// getSFTPClient returns the shared SFTP client, which runs as the user to
+// become when sudo is true. The client is created on first use, and created
+// again after its connection fails.
+func (c *RemoteClient) getSFTPClient(ctx context.Context, sudo bool) (*sftp.Client, error) {
+	c.sftpMux.Lock()
+	defer c.sftpMux.Unlock()
+
+	if client, ok := c.sftpClients[sudo]; ok {
+		return client, nil
+	}
+
+	client, err := c.newSFTPClient(ctx, sudo)
+	if err != nil {
+		return nil, err
+	}
+	c.sftpClients[sudo] = client
+
+	go func() {
+		_ = client.Wait()
+
+		c.sftpMux.Lock()
+		defer c.sftpMux.Unlock()
+		if c.sftpClients[sudo] == client {
+			delete(c.sftpClients, sudo)
+		}
+	}()
+
+	return client, nil
+}
+
+// sftpSessions returns the number of sessions held by shared SFTP clients.
+func (c *RemoteClient) sftpSessions() int {
+	c.sftpMux.Lock()
+	defer c.sftpMux.Unlock()
+
+	return len(c.sftpClients)
+}
+
+// newSFTPClient starts a SFTP client, which runs as the user to become when
+// sudo is true, by starting the SFTP server at sftpServerPath with sudo or
+// become.
+func (c *RemoteClient) newSFTPClient(ctx context.Context, sudo bool) (*sftp.Client, error) {
+	if !sudo {
+		var client *sftp.Client
+		err := c.retry.do(ctx, isSessionRefused, func() (err error) {
+			client, err = sftp.NewClient(c.sshClient)
+			return err
+		})
+		return client, err
+	}
+
+	session, err := c.newSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var stderr bytes.Buffer
+	session.Stderr = &stderr
+
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+
+	cmd := newShellCommand(c.sftpServerPath).withBecome(c.become)
+	if err := session.Start(cmd.String()); err != nil {
+		session.Close()
+		return nil, err
+	}
+
+	// The password is read by sudo before the SFTP server is started, so it
+	// must be written before the SFTP client starts the protocol handshake.
+	if password := cmd.stdin(nil); password != nil {
+		if _, err := io.Copy(stdin, password); err != nil {
+			session.Close()
+			return nil, err
+		}
+	}
+
+	client, err := sftp.NewClientPipe(stdout, stdin)
+	if err != nil {
+		session.Close()
+		_ = session.Wait()
+		return nil, Error{
+			cmd:    cmd.String(),
+			err:    err,
+			stderr: stderr.Bytes(),
+		}
+	}
+
+	// The SFTP server exits when the client is closed.
+	go func() {
+		_ = session.Wait()
+		session.Close()
+	}()
+
+	return client, nil
+}
+
+// useShell returns whether files are accessed with shell commands, which is
+// the case when sudo is true, unless the SFTP server is started with sudo.
+func (c *RemoteClient) useShell(sudo bool) bool {
+	return sudo && c.sftpServerPath == ""
 }
@@ -0,0 +1,32 @@
+package provider
+
+import (
+	"os"
+	"testing"
+)
+
+func TestUnixFileMode(t *testing.T) {
+	tests := []struct {
+		mode        uint32
+		fileType    os.FileMode
+		permissions string
+	}{
+		{mode: 0100644, fileType: 0, permissions: "0644"},
+		{mode: 0104755, fileType: 0, permissions: "4755"},
+		{mode: 0041777, fileType: os.ModeDir, permissions: "1777"},
+		{mode: 0042750, fileType: os.ModeDir, permissions: "2750"},
+		{mode: 0120777, fileType: os.ModeSymlink, permissions: "0777"},
+		{mode: 0020620, fileType: os.ModeDevice | os.ModeCharDevice, permissions: "0620"},
+		{mode: 0010600, fileType: os.ModeNamedPipe, permissions: "0600"},
+	}
+
+	for _, test := range tests {
+		mode := unixFileMode(test.mode)
+		if mode.Type() != test.fileType {
+			t.Errorf("unixFileMode(%o) has type %s, want %s", test.mode, mode.Type(), test.fileType)
+		}
+		if permissions := (FileInfo{Mode: mode}).Permissions(); permissions != test.permissions {
+			t.Errorf("unixFileMode(%o) has permissions %s, want %s", test.mode, permissions, test.permissions)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/id"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+func resourceRemoteCommand() *schema.Resource {
+	return &schema.Resource{
+		Description: "Commands run on remote host when the resource is created, updated and destroyed.",
+
+		CreateContext: resourceRemoteCommandCreate,
+		ReadContext:   resourceRemoteCommandRead,
+		UpdateContext: resourceRemoteCommandUpdate,
+		DeleteContext: resourceRemoteCommandDelete,
+
+		CustomizeDiff: resourceRemoteCommandCustomizeDiff,
+
+		Schema: map[string]*schema.Schema{
+			"conn": {
+				Type:        schema.TypeList,
+				MinItems:    0,
+				MaxItems:    1,
+				Optional:    true,
+				Description: "Connection to host where commands are run.",
+				Elem:        connectionSchemaResource,
+			},
+			"create": {
+				Description: "Command run when the resource is created. Changing the command replaces the resource, unless `update` is set.",
+				Type:        schema.TypeString,
+				Required:    true,
+			},
+			"update": {
+				Description: "Command run instead of replacing the resource when `create` or `update` is changed.",
+				Type:        schema.TypeString,
+				Optional:    true,
+			},
+			"delete": {
+				Description: "Command run when the resource is destroyed.",
+				Type:        schema.TypeString,
+				Optional:    true,
+			},
+			"read": {
+				Description: "Command run when the resource is refreshed. The resource is created again if the command exits with a non-zero status.",
+				Type:        schema.TypeString,
+				Optional:    true,
+			},
+			"triggers": {
+				Description: "Arbitrary values that replace the resource when changed.",
+				Type:        schema.TypeMap,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Optional:    true,
+				ForceNew:    true,
+			},
+			"stdout": {
+				Description: "Standard output of the last `create` or `update` command.",
+				Type:        schema.TypeString,
+				Computed:    true,
+			},
+			"stderr": {
+				Description: "Standard error of the last `create` or `update` command.",
+				Type:        schema.TypeString,
+				Computed:    true,
+			},
+		},
+	}
+}
+
+func resourceRemoteCommandCreate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	diags := resourceRemoteCommandRun(ctx, d, meta, "create")
+	if !diags.HasError() {
+		d.SetId(id.UniqueId())
+	}
+	return diags
+}
+
+func resourceRemoteCommandRead(ctx context.Context, d *schema.ResourceData, meta interface{}) (error diag.Diagnostics) {
+	cmd, ok, err := GetOk[string](d, "read")
+	if err != nil {
+		return diag.Diagnostics{{Severity: diag.Error, Summary: err.Error()}}
+	}
+	if !ok {
+		return diag.Diagnostics{}
+	}
+
+	conn, err := meta.(*apiClient).getConnWithDefault(d)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	client, err := meta.(*apiClient).getRemoteClient(ctx, conn)
+	if err != nil {
+		return diag.Errorf("unable to open remote client: %s", err.Error())
+	}
+	defer func() {
+		if err := meta.(*apiClient).closeRemoteClient(conn, client); err != nil {
+			error = append(error, diag.Errorf("unable to close remote client: %s", err.Error())...)
+		}
+	}()
+
+	sudo := conn.sudo
+
+	stdout, stderr, err := client.RunCommand(ctx, cmd, sudo)
+	if err != nil {
+		if _, ok := exitStatus(err); ok {
+			d.SetId("")
+			return diag.Diagnostics{}
+		}
+		return diag.Diagnostics{{
+			Severity: diag.Error,
+			Summary:  fmt.Sprintf("unable to run read command: %s", err.Error()),
+			Detail:   commandOutput(stdout, stderr),
+		}}
+	}
+
+	return diag.Diagnostics{}
+}
+
+func resourceRemoteCommandUpdate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	// Removing `update` replaces nothing and runs nothing.
+	if _, ok := d.GetOk("update"); !ok || !d.HasChanges("create", "update") {
+		return diag.Diagnostics{}
+	}
+	return resourceRemoteCommandRun(ctx, d, meta, "update")
+}
+
+func resourceRemoteCommandDelete(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	if _, ok := d.GetOk("delete"); !ok {
+		return diag.Diagnostics{}
+	}
+	return resourceRemoteCommandRun(ctx, d, meta, "delete")
+}
+
+func resourceRemoteCommandCustomizeDiff(ctx context.Context, d *schema.ResourceDiff, meta interface{}) error {
+	if d.Id() == "" || !d.HasChanges("create", "update") {
+		return nil
+	}
+	if _, ok := d.GetOk("update"); !ok {
+		if d.HasChange("create") {
+			return d.ForceNew("create")
+		}
+		return nil
+	}
+
+	// The output of the update command is unknown until it has run.
+	if err := d.SetNewComputed("stdout"); err != nil {
+		return err
+	}
+	return d.SetNewComputed("stderr")
+}
+
+// resourceRemoteCommandRun runs the command in the attribute key, and stores
+// its output unless the resource is being destroyed.
+func resourceRemoteCommandRun(ctx context.Context, d *schema.ResourceData, meta interface{}, key string) (error diag.Diagnostics) {
+	conn, err := meta.(*apiClient).getConnWithDefault(d)
+	if err != nil {
+		return diag.Diagnostics{{Severity: diag.Error, Summary: err.Error()}}
+	}
+
+	client, err := meta.(*apiClient).getRemoteClient(ctx, conn)
+	if err != nil {
+		return diag.Errorf("unable to open remote client: %s", err.Error())
+	}
+	defer func() {
+		if err := meta.(*apiClient).closeRemoteClient(conn, client); err != nil {
+			error = append(error, diag.Errorf("unable to close remote client: %s", err.Error())...)
+		}
+	}()
+
+	sudo := conn.sudo
+
+	cmd, err := Get[string](d, key)
+	if err != nil {
+		return diag.Diagnostics{{Severity: diag.Error, Summary: err.Error()}}
+	}
+
+	stdout, stderr, err := client.RunCommand(ctx, cmd, sudo)
+	if err != nil {
+		return diag.Diagnostics{{
+			Severity: diag.Error,
+			Summary:  fmt.Sprintf("unable to run %s command: %s", key, err.Error()),
+			Detail:   commandOutput(stdout, stderr),
+		}}
+	}
+
+	if key == "delete" {
+		return diag.Diagnostics{}
+	}
+
+	if err := d.Set("stdout", stdout); err != nil {
+		return diag.FromErr(err)
+	}
+	if err := d.Set("stderr", stderr); err != nil {
+		return diag.FromErr(err)
+	}
+
+	return diag.Diagnostics{}
+}
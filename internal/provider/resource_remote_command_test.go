@@ -0,0 +1,152 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+)
+
+func TestAccResourceRemoteCommand(t *testing.T) {
+	config := func(create string, update string) string {
+		return fmt.Sprintf(`
+		resource "remote_command" "command_1" {
+			provider = remotehost
+			create = "%s"
+			%s
+			delete = "rm /tmp/command_1.txt"
+			read = "test -f /tmp/command_1.txt"
+		}
+
+		data "remote_file" "command_1" {
+			provider = remotehost
+			path = "/tmp/command_1.txt"
+			depends_on = [remote_command.command_1]
+		}
+		`, create, update)
+	}
+	update := `update = "echo updated > /tmp/command_1.txt && echo updated"`
+
+	resource.UnitTest(t, resource.TestCase{
+		PreCheck:                 func() { testAccPreCheck(t) },
+		ProtoV5ProviderFactories: protoV5ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: config("echo created > /tmp/command_1.txt && echo created", update),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr(
+						"remote_command.command_1", "stdout", "created\n"),
+					resource.TestCheckResourceAttr(
+						"data.remote_file.command_1", "content", "created\n"),
+				),
+			},
+			{
+				Config: config("echo created > /tmp/command_1.txt && echo created again", update),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr(
+						"remote_command.command_1", "stdout", "updated\n"),
+					resource.TestCheckResourceAttr(
+						"data.remote_file.command_1", "content", "updated\n"),
+				),
+			},
+			{
+				// Removing update neither runs a command nor replaces the
+				// resource
+				Config: config("echo created > /tmp/command_1.txt && echo created again", ""),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr(
+						"remote_command.command_1", "stdout", "updated\n"),
+					resource.TestCheckResourceAttr(
+						"data.remote_file.command_1", "content", "updated\n"),
+				),
+			},
+		},
+	})
+}
+
+func TestAccResourceRemoteCommandTriggers(t *testing.T) {
+	config := func(trigger string) string {
+		return fmt.Sprintf(`
+		resource "remote_command" "command_2" {
+			conn {
+				host = "remotehost"
+				user = "root"
+				password = "password"
+				sudo = true
+			}
+			create = "echo %s >> /tmp/command_2.txt"
+			delete = "rm -f /tmp/command_2.txt"
+			triggers = {
+				value = "%s"
+			}
+		}
+
+		data "remote_file" "command_2" {
+			provider = remotehost
+			path = "/tmp/command_2.txt"
+			depends_on = [remote_command.command_2]
+		}
+		`, trigger, trigger)
+	}
+
+	resource.UnitTest(t, resource.TestCase{
+		PreCheck:                 func() { testAccPreCheck(t) },
+		ProtoV5ProviderFactories: protoV5ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: config("first"),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr(
+						"data.remote_file.command_2", "content", "first\n"),
+				),
+			},
+			{
+				Config: config("second"),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr(
+						"data.remote_file.command_2", "content", "second\n"),
+				),
+			},
+		},
+	})
+}
+
+func TestResourceRemoteCommandDiff(t *testing.T) {
+	state := &terraform.InstanceState{
+		ID: "command",
+		Attributes: map[string]string{
+			"id":     "command",
+			"create": "echo created",
+			"update": "echo updated",
+			"stdout": "updated\n",
+			"stderr": "",
+		},
+	}
+
+	tests := []struct {
+		config       map[string]interface{}
+		wantReplace  bool
+		wantComputed bool
+	}{
+		{config: map[string]interface{}{"create": "echo created again", "update": "echo updated"}, wantReplace: false, wantComputed: true},
+		{config: map[string]interface{}{"create": "echo created", "update": "echo updated again"}, wantReplace: false, wantComputed: true},
+		{config: map[string]interface{}{"create": "echo created"}, wantReplace: false, wantComputed: false},
+		{config: map[string]interface{}{"create": "echo created again"}, wantReplace: true, wantComputed: true},
+	}
+
+	for i, test := range tests {
+		diff, err := resourceRemoteCommand().Diff(context.Background(), state, terraform.NewResourceConfigRaw(test.config), nil)
+		if err != nil {
+			t.Fatalf("test %d: %s", i, err.Error())
+		}
+		if diff.RequiresNew() != test.wantReplace {
+			t.Errorf("test %d replaces the resource: %v, want %v", i, diff.RequiresNew(), test.wantReplace)
+		}
+		stdout := diff.Attributes["stdout"]
+		if computed := stdout != nil && stdout.NewComputed; computed != test.wantComputed {
+			t.Errorf("test %d plans unknown stdout: %v, want %v", i, computed, test.wantComputed)
+		}
+	}
+}
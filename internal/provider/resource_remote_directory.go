@@ -0,0 +1,320 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"strconv"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+func resourceRemoteDirectory() *schema.Resource {
+	return &schema.Resource{
+		Description: "Directory on remote host.",
+
+		CreateContext: resourceRemoteDirectoryCreate,
+		ReadContext:   resourceRemoteDirectoryRead,
+		UpdateContext: resourceRemoteDirectoryUpdate,
+		DeleteContext: resourceRemoteDirectoryDelete,
+
+		Schema: map[string]*schema.Schema{
+			"conn": {
+				Type:        schema.TypeList,
+				MinItems:    0,
+				MaxItems:    1,
+				Optional:    true,
+				Description: "Connection to host where directories are located.",
+				Elem:        connectionSchemaResource,
+			},
+			"path": {
+				Description: "Path to directory on remote host.",
+				Type:        schema.TypeString,
+				ForceNew:    true,
+				Required:    true,
+			},
+			"permissions": {
+				Description: "Permissions of directory (in octal form).",
+				Type:        schema.TypeString,
+				Default:     "0755",
+				Optional:    true,
+			},
+			"group": {
+				Description: "Group ID (GID) of directory owner. Mutually exclusive with `group_name`.",
+				Type:        schema.TypeString,
+				Optional:    true,
+			},
+			"group_name": {
+				Description:   "Group name of directory owner. Mutually exclusive with `group`.",
+				Type:          schema.TypeString,
+				Optional:      true,
+				ConflictsWith: []string{"group"},
+			},
+			"owner": {
+				Description: "User ID (UID) of directory owner. Mutually exclusive with `owner_name`.",
+				Type:        schema.TypeString,
+				Optional:    true,
+			},
+			"owner_name": {
+				Description:   "User name of directory owner. Mutually exclusive with `owner`.",
+				Type:          schema.TypeString,
+				Optional:      true,
+				ConflictsWith: []string{"owner"},
+			},
+			"recursive": {
+				Description: "Create missing parent directories, like `mkdir -p`. Parent directories are not removed when the directory is destroyed, and get default permissions and ownership.",
+				Type:        schema.TypeBool,
+				Default:     false,
+				Optional:    true,
+			},
+			"force_destroy": {
+				Description: "Remove the directory along with its content when destroyed. When `false`, destroying a non-empty directory fails. The content of adopted directories is never removed.",
+				Type:        schema.TypeBool,
+				Default:     false,
+				Optional:    true,
+			},
+			"adopted": {
+				Description: "Whether the directory already existed when the resource was created. Adopted directories are only removed when empty, as their content was not created by Terraform.",
+				Type:        schema.TypeBool,
+				Computed:    true,
+			},
+		},
+	}
+}
+
+func resourceRemoteDirectoryCreate(ctx context.Context, d *schema.ResourceData, meta interface{}) (error diag.Diagnostics) {
+	conn, err := meta.(*apiClient).getConnWithDefault(d)
+	if err != nil {
+		return diag.Diagnostics{{Severity: diag.Error, Summary: err.Error()}}
+	}
+
+	if err := setResourceID(d, conn); err != nil {
+		return diag.FromErr(err)
+	}
+
+	client, err := meta.(*apiClient).getRemoteClient(ctx, conn)
+	if err != nil {
+		return diag.Errorf("unable to open remote client: %s", err.Error())
+	}
+	defer func() {
+		if err := meta.(*apiClient).closeRemoteClient(conn, client); err != nil {
+			error = append(error, diag.Errorf("unable to close remote client: %s", err.Error())...)
+		}
+	}()
+
+	sudo := conn.sudo
+
+	path, err := Get[string](d, "path")
+	if err != nil {
+		return diag.Diagnostics{{Severity: diag.Error, Summary: err.Error()}}
+	}
+
+	permissions, err := Get[string](d, "permissions")
+	if err != nil {
+		return diag.Diagnostics{{Severity: diag.Error, Summary: err.Error()}}
+	}
+
+	recursive, _, err := GetOk[bool](d, "recursive")
+	if err != nil {
+		return diag.Diagnostics{{Severity: diag.Error, Summary: err.Error()}}
+	}
+
+	var group string
+	if g, ok, err := GetOk[string](d, "group"); ok {
+		if err != nil {
+			return diag.Diagnostics{{Severity: diag.Error, Summary: err.Error()}}
+		}
+		group = g
+	} else if g, ok, err := GetOk[string](d, "group_name"); ok {
+		if err != nil {
+			return diag.Diagnostics{{Severity: diag.Error, Summary: err.Error()}}
+		}
+		group = g
+	}
+
+	var owner string
+	if o, ok, err := GetOk[string](d, "owner"); ok {
+		if err != nil {
+			return diag.Diagnostics{{Severity: diag.Error, Summary: err.Error()}}
+		}
+		owner = o
+	} else if o, ok, err := GetOk[string](d, "owner_name"); ok {
+		if err != nil {
+			return diag.Diagnostics{{Severity: diag.Error, Summary: err.Error()}}
+		}
+		owner = o
+	}
+
+	exists, err := client.DirectoryExists(ctx, path, sudo)
+	if err != nil {
+		return diag.Errorf("unable to check if remote directory exists: %s", err.Error())
+	}
+	if !exists {
+		if err := client.CreateDirectory(ctx, path, recursive, sudo); err != nil {
+			return diag.Errorf("unable to create remote directory: %s", err.Error())
+		}
+	}
+	if d.IsNewResource() {
+		if err := d.Set("adopted", exists); err != nil {
+			return diag.FromErr(err)
+		}
+	}
+
+	if err := client.ChmodFile(ctx, path, permissions, sudo); err != nil {
+		return diag.Errorf("unable to change permissions of remote directory: %s", err.Error())
+	}
+
+	if group != "" {
+		if err := client.ChgrpFile(ctx, path, group, sudo); err != nil {
+			return diag.Errorf("unable to change group of remote directory: %s", err.Error())
+		}
+	}
+
+	if owner != "" {
+		if err := client.ChownFile(ctx, path, owner, sudo); err != nil {
+			return diag.Errorf("unable to change owner of remote directory: %s", err.Error())
+		}
+	}
+
+	return diag.Diagnostics{}
+}
+
+func resourceRemoteDirectoryRead(ctx context.Context, d *schema.ResourceData, meta interface{}) (error diag.Diagnostics) {
+	conn, err := meta.(*apiClient).getConnWithDefault(d)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	if err := setResourceID(d, conn); err != nil {
+		return diag.FromErr(err)
+	}
+
+	client, err := meta.(*apiClient).getRemoteClient(ctx, conn)
+	if err != nil {
+		return diag.Errorf("unable to open remote client: %s", err.Error())
+	}
+	defer func() {
+		if err := meta.(*apiClient).closeRemoteClient(conn, client); err != nil {
+			error = append(error, diag.Errorf("unable to close remote client: %s", err.Error())...)
+		}
+	}()
+
+	sudo := conn.sudo
+
+	path, err := Get[string](d, "path")
+	if err != nil {
+		return diag.Diagnostics{{Severity: diag.Error, Summary: err.Error()}}
+	}
+
+	_, groupOk, err := GetOk[string](d, "group")
+	if err != nil {
+		return diag.Diagnostics{{Severity: diag.Error, Summary: err.Error()}}
+	}
+
+	_, groupNameOk, err := GetOk[string](d, "group_name")
+	if err != nil {
+		return diag.Diagnostics{{Severity: diag.Error, Summary: err.Error()}}
+	}
+
+	_, ownerOk, err := GetOk[string](d, "owner")
+	if err != nil {
+		return diag.Diagnostics{{Severity: diag.Error, Summary: err.Error()}}
+	}
+
+	_, ownerNameOk, err := GetOk[string](d, "owner_name")
+	if err != nil {
+		return diag.Diagnostics{{Severity: diag.Error, Summary: err.Error()}}
+	}
+
+	info, err := client.Stat(ctx, path, sudo)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return diag.Errorf("unable to stat remote directory: %s", err.Error())
+	}
+	if err != nil || !info.Mode.IsDir() {
+		d.SetId("")
+		return diag.Diagnostics{}
+	}
+
+	if err := d.Set("permissions", info.Permissions()); err != nil {
+		return diag.FromErr(err)
+	}
+
+	if ownerOk {
+		if err := d.Set("owner", strconv.Itoa(info.UID)); err != nil {
+			return diag.FromErr(err)
+		}
+	}
+	if ownerNameOk {
+		if err := d.Set("owner_name", info.UserName); err != nil {
+			return diag.FromErr(err)
+		}
+	}
+
+	if groupOk {
+		if err := d.Set("group", strconv.Itoa(info.GID)); err != nil {
+			return diag.FromErr(err)
+		}
+	}
+	if groupNameOk {
+		if err := d.Set("group_name", info.GroupName); err != nil {
+			return diag.FromErr(err)
+		}
+	}
+
+	return diag.Diagnostics{}
+}
+
+func resourceRemoteDirectoryUpdate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	return resourceRemoteDirectoryCreate(ctx, d, meta)
+}
+
+func resourceRemoteDirectoryDelete(ctx context.Context, d *schema.ResourceData, meta interface{}) (error diag.Diagnostics) {
+	conn, err := meta.(*apiClient).getConnWithDefault(d)
+	if err != nil {
+		return diag.Diagnostics{{Severity: diag.Error, Summary: err.Error()}}
+	}
+
+	client, err := meta.(*apiClient).getRemoteClient(ctx, conn)
+	if err != nil {
+		return diag.Errorf("unable to open remote client: %s", err.Error())
+	}
+	defer func() {
+		if err := meta.(*apiClient).closeRemoteClient(conn, client); err != nil {
+			error = append(error, diag.Errorf("unable to close remote client: %s", err.Error())...)
+		}
+	}()
+
+	sudo := conn.sudo
+
+	path, err := Get[string](d, "path")
+	if err != nil {
+		return diag.Diagnostics{{Severity: diag.Error, Summary: err.Error()}}
+	}
+
+	forceDestroy, _, err := GetOk[bool](d, "force_destroy")
+	if err != nil {
+		return diag.Diagnostics{{Severity: diag.Error, Summary: err.Error()}}
+	}
+
+	adopted, _, err := GetOk[bool](d, "adopted")
+	if err != nil {
+		return diag.Diagnostics{{Severity: diag.Error, Summary: err.Error()}}
+	}
+
+	exists, err := client.DirectoryExists(ctx, path, sudo)
+	if err != nil {
+		return diag.Errorf("unable to check if remote directory exists: %s", err.Error())
+	}
+	if exists {
+		// Content that Terraform did not create is never removed.
+		if err := client.DeleteDirectory(ctx, path, forceDestroy && !adopted, sudo); err != nil {
+			if forceDestroy && adopted {
+				return diag.Errorf("unable to delete remote directory, which already existed when created and is only removed when empty: %s", err.Error())
+			}
+			return diag.Errorf("unable to delete remote directory: %s", err.Error())
+		}
+	}
+
+	return diag.Diagnostics{}
+}
@@ -0,0 +1,193 @@
+package provider
+
+import (
+	"fmt"
+	"regexp"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+)
+
+func TestAccResourceRemoteDirectory(t *testing.T) {
+	resource.UnitTest(t, resource.TestCase{
+		PreCheck:                 func() { testAccPreCheck(t) },
+		ProtoV5ProviderFactories: protoV5ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: `
+				resource "remote_directory" "directory_1" {
+					conn {
+						host = "remotehost"
+						user = "root"
+						password = "password"
+					}
+					path = "/tmp/directory_1"
+					permissions = "0700"
+				}
+				`,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestMatchResourceAttr(
+						"remote_directory.directory_1", "id", regexp.MustCompile("remotehost:22:/tmp/directory_1")),
+					resource.TestMatchResourceAttr(
+						"remote_directory.directory_1", "permissions", regexp.MustCompile("0700")),
+					resource.TestCheckResourceAttr(
+						"remote_directory.directory_1", "adopted", "false"),
+				),
+			},
+		},
+	})
+}
+
+func TestAccResourceRemoteDirectoryRecursive(t *testing.T) {
+	resource.UnitTest(t, resource.TestCase{
+		PreCheck:                 func() { testAccPreCheck(t) },
+		ProtoV5ProviderFactories: protoV5ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: `
+				resource "remote_directory" "directory_2" {
+					conn {
+						host = "remotehost"
+						user = "root"
+						sudo = true
+						password = "password"
+					}
+					path = "/tmp/directory_2/a/b"
+					recursive = true
+					owner = "1000"
+					group_name = "root"
+				}
+				`,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestMatchResourceAttr(
+						"remote_directory.directory_2", "permissions", regexp.MustCompile("0755")),
+					resource.TestMatchResourceAttr(
+						"remote_directory.directory_2", "owner", regexp.MustCompile("1000")),
+					resource.TestMatchResourceAttr(
+						"remote_directory.directory_2", "group_name", regexp.MustCompile("root")),
+				),
+			},
+		},
+	})
+}
+
+func TestAccResourceRemoteDirectoryForceDestroy(t *testing.T) {
+	resource.UnitTest(t, resource.TestCase{
+		PreCheck:                 func() { testAccPreCheck(t) },
+		ProtoV5ProviderFactories: protoV5ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: `
+				resource "remote_directory" "directory_3" {
+					provider = remotehost
+					path = "/tmp/directory_3"
+					force_destroy = true
+				}
+				`,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestMatchResourceAttr(
+						"remote_directory.directory_3", "force_destroy", regexp.MustCompile("true")),
+				),
+			},
+			{
+				// Add an unmanaged file, which must be removed with the directory
+				PreConfig: func() {
+					writeFileToHost("remotehost:22", "/tmp/directory_3/data.txt", "data", "root", "root")
+				},
+				Config: `
+				resource "remote_directory" "directory_3" {
+					provider = remotehost
+					path = "/tmp/directory_3"
+					force_destroy = true
+				}
+				`,
+			},
+		},
+	})
+}
+
+func TestAccResourceRemoteDirectoryWithoutForceDestroy(t *testing.T) {
+	config := func(forceDestroy bool) string {
+		return fmt.Sprintf(`
+		resource "remote_directory" "directory_4" {
+			provider = remotehost
+			path = "/tmp/directory_4"
+			force_destroy = %t
+		}
+		`, forceDestroy)
+	}
+
+	resource.UnitTest(t, resource.TestCase{
+		PreCheck:                 func() { testAccPreCheck(t) },
+		ProtoV5ProviderFactories: protoV5ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: config(false),
+			},
+			{
+				// Add an unmanaged file, which must keep the directory from
+				// being destroyed
+				PreConfig: func() {
+					writeFileToHost("remotehost:22", "/tmp/directory_4/data.txt", "data", "root", "root")
+				},
+				Config:      config(false),
+				Destroy:     true,
+				ExpectError: regexp.MustCompile("unable to delete remote directory"),
+			},
+			{
+				// The directory and its content are left in place
+				Config: config(true) + `
+				data "remote_file" "directory_4_data" {
+					provider = remotehost
+					path = "/tmp/directory_4/data.txt"
+				}
+				`,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr(
+						"data.remote_file.directory_4_data", "content", "data"),
+				),
+			},
+		},
+	})
+}
+
+func TestAccResourceRemoteDirectoryAdopted(t *testing.T) {
+	config := `
+	resource "remote_directory" "directory_5" {
+		provider = remotehost
+		path = "/tmp/directory_5"
+		force_destroy = true
+	}
+	`
+
+	resource.UnitTest(t, resource.TestCase{
+		PreCheck:                 func() { testAccPreCheck(t) },
+		ProtoV5ProviderFactories: protoV5ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				PreConfig: func() {
+					runCommandOnHost("remotehost:22", "mkdir -p /tmp/directory_5 && echo data > /tmp/directory_5/data.txt")
+				},
+				Config: config,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr(
+						"remote_directory.directory_5", "adopted", "true"),
+				),
+			},
+			{
+				// Content that was not created by Terraform is kept, even
+				// with force_destroy
+				Config:      config,
+				Destroy:     true,
+				ExpectError: regexp.MustCompile("already existed when created"),
+			},
+			{
+				// The adopted directory is removed once empty
+				PreConfig: func() {
+					runCommandOnHost("remotehost:22", "rm /tmp/directory_5/data.txt")
+				},
+				Config: config,
+			},
+		},
+	})
+}
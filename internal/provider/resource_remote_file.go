@@ -2,317 +2,922 @@ package provider
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	pathpkg "path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/hashicorp/terraform-plugin-framework-validators/stringvalidator"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/booldefault"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/int64default"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/int64planmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/planmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/stringdefault"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/stringplanmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/schema/validator"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
 
-	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
-	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+var (
+	_ resource.ResourceWithConfigure      = &remoteFileResource{}
+	_ resource.ResourceWithModifyPlan     = &remoteFileResource{}
+	_ resource.ResourceWithImportState    = &remoteFileResource{}
+	_ resource.ResourceWithUpgradeState   = &remoteFileResource{}
+	_ resource.ResourceWithValidateConfig = &remoteFileResource{}
 )
 
-func resourceRemoteFile() *schema.Resource {
-	return &schema.Resource{
-		Description: "File on remote host.",
-
-		CreateContext: resourceRemoteFileCreate,
-		ReadContext:   resourceRemoteFileRead,
-		UpdateContext: resourceRemoteFileUpdate,
-		DeleteContext: resourceRemoteFileDelete,
-
-		Schema: map[string]*schema.Schema{
-			"conn": {
-				Type:        schema.TypeList,
-				MinItems:    0,
-				MaxItems:    1,
-				Optional:    true,
-				Description: "Connection to host where files are located.",
-				Elem:        connectionSchemaResource,
+func NewRemoteFileResource() resource.Resource {
+	return &remoteFileResource{}
+}
+
+type remoteFileResource struct {
+	client *apiClient
+}
+
+type remoteFileResourceModel struct {
+	ID               types.String      `tfsdk:"id"`
+	Conn             []connectionModel `tfsdk:"conn"`
+	Path             types.String      `tfsdk:"path"`
+	Content          types.String      `tfsdk:"content"`
+	ContentBase64    types.String      `tfsdk:"content_base64"`
+	Source           types.String      `tfsdk:"source"`
+	Permissions      types.String      `tfsdk:"permissions"`
+	Group            types.String      `tfsdk:"group"`
+	GroupName        types.String      `tfsdk:"group_name"`
+	Owner            types.String      `tfsdk:"owner"`
+	OwnerName        types.String      `tfsdk:"owner_name"`
+	Atomic           types.Bool        `tfsdk:"atomic"`
+	ValidateCommand  types.String      `tfsdk:"validate_command"`
+	OnCreateCommand  types.String      `tfsdk:"on_create_command"`
+	OnUpdateCommand  types.String      `tfsdk:"on_update_command"`
+	OnDestroyCommand types.String      `tfsdk:"on_destroy_command"`
+	Backup           types.Bool        `tfsdk:"backup"`
+	BackupSuffix     types.String      `tfsdk:"backup_suffix"`
+	BackupKeep       types.Int64       `tfsdk:"backup_keep"`
+	BackupPath       types.String      `tfsdk:"backup_path"`
+	StoreContent     types.Bool        `tfsdk:"store_content"`
+	SHA256           types.String      `tfsdk:"sha256"`
+	SHA1             types.String      `tfsdk:"sha1"`
+	MD5              types.String      `tfsdk:"md5"`
+	Size             types.Int64       `tfsdk:"size"`
+	HostKey          types.String      `tfsdk:"host_key"`
+}
+
+func (r *remoteFileResource) Metadata(ctx context.Context, req resource.MetadataRequest, resp *resource.MetadataResponse) {
+	resp.TypeName = req.ProviderTypeName + "_file"
+}
+
+func (r *remoteFileResource) Schema(ctx context.Context, req resource.SchemaRequest, resp *resource.SchemaResponse) {
+	resp.Schema = remoteFileResourceSchema()
+}
+
+// remoteFileResourceSchema returns the schema of the resource. Version 0 was
+// written by the SDK, which stored strings and numbers that were not set as
+// their zero value.
+func remoteFileResourceSchema() schema.Schema {
+	contentAttributes := []path.Expression{
+		path.MatchRoot("content"),
+		path.MatchRoot("content_base64"),
+		path.MatchRoot("source"),
+	}
+
+	return schema.Schema{
+		MarkdownDescription: "File on remote host.",
+		Version:             1,
+
+		Blocks: map[string]schema.Block{
+			"conn": connectionBlock("Connection to host where files are located."),
+		},
+
+		Attributes: map[string]schema.Attribute{
+			"id": schema.StringAttribute{
+				MarkdownDescription: "The ID of this resource.",
+				Computed:            true,
+				PlanModifiers:       []planmodifier.String{stringplanmodifier.UseStateForUnknown()},
+			},
+			"path": schema.StringAttribute{
+				MarkdownDescription: "Path to file on remote host.",
+				Required:            true,
+				PlanModifiers:       []planmodifier.String{stringplanmodifier.RequiresReplace()},
+			},
+			"content": schema.StringAttribute{
+				MarkdownDescription: "Content of file. Exactly one of `content`, `content_base64` and `source` must be set.",
+				Optional:            true,
+				Validators:          []validator.String{stringvalidator.ExactlyOneOf(contentAttributes...)},
+			},
+			"content_base64": schema.StringAttribute{
+				MarkdownDescription: "Base64 encoded content of file, for binary content that is not valid UTF-8. Exactly one of `content`, `content_base64` and `source` must be set.",
+				Optional:            true,
+				Validators: []validator.String{
+					stringvalidator.ExactlyOneOf(contentAttributes...),
+					base64Validator{},
+				},
+			},
+			"source": schema.StringAttribute{
+				MarkdownDescription: "Local path to a file whose content is copied to the remote file. The content is not stored in state, changes are detected by comparing the hashes of the local and remote content. Exactly one of `content`, `content_base64` and `source` must be set.",
+				Optional:            true,
+				Validators:          []validator.String{stringvalidator.ExactlyOneOf(contentAttributes...)},
+			},
+			"permissions": schema.StringAttribute{
+				MarkdownDescription: "Permissions of file (in octal form). Defaults to `0644`.",
+				Optional:            true,
+				Computed:            true,
+				Default:             stringdefault.StaticString("0644"),
+			},
+			"group": schema.StringAttribute{
+				MarkdownDescription: "Group ID (GID) of file owner. Mutually exclusive with `group_name`.",
+				Optional:            true,
+			},
+			"group_name": schema.StringAttribute{
+				MarkdownDescription: "Group name of file owner. Mutually exclusive with `group`.",
+				Optional:            true,
+				Validators:          []validator.String{stringvalidator.ConflictsWith(path.MatchRoot("group"))},
+			},
+			"owner": schema.StringAttribute{
+				MarkdownDescription: "User ID (UID) of file owner. Mutually exclusive with `owner_name`.",
+				Optional:            true,
+			},
+			"owner_name": schema.StringAttribute{
+				MarkdownDescription: "User name of file owner. Mutually exclusive with `owner`.",
+				Optional:            true,
+				Validators:          []validator.String{stringvalidator.ConflictsWith(path.MatchRoot("owner"))},
+			},
+			"atomic": schema.BoolAttribute{
+				MarkdownDescription: "Write the content to a temporary file in the same directory, and rename it over the file once its permissions and ownership are set and its content is flushed to disk. Prevents partially written files, but replaces the inode of the file. Defaults to `false`.",
+				Optional:            true,
+				Computed:            true,
+				Default:             booldefault.StaticBool(false),
+			},
+			"validate_command": schema.StringAttribute{
+				MarkdownDescription: "Command that validates the new content before the file is written, such as `visudo -cf %s`. `%s` is replaced by the path to a temporary file with the new content, permissions and ownership, in the same directory as the file. The file is left untouched when the command fails.",
+				Optional:            true,
+				Validators:          []validator.String{stringvalidator.RegexMatches(regexp.MustCompile("%s"), "must contain %s, which is replaced by the path to the file to validate")},
 			},
-			"path": {
-				Description: "Path to file on remote host.",
-				Type:        schema.TypeString,
-				ForceNew:    true,
-				Required:    true,
+			"on_create_command": schema.StringAttribute{
+				MarkdownDescription: "Command run on the remote host after the file is created.",
+				Optional:            true,
 			},
-			"content": {
-				Description: "Content of file.",
-				Type:        schema.TypeString,
-				Required:    true,
+			"on_update_command": schema.StringAttribute{
+				MarkdownDescription: "Command run on the remote host after the content, permissions or ownership of the file is changed, such as `systemctl reload nginx`.",
+				Optional:            true,
 			},
-			"permissions": {
-				Description: "Permissions of file (in octal form).",
-				Type:        schema.TypeString,
-				Default:     "0644",
-				Optional:    true,
+			"on_destroy_command": schema.StringAttribute{
+				MarkdownDescription: "Command run on the remote host after the file is deleted.",
+				Optional:            true,
 			},
-			"group": {
-				Description: "Group ID (GID) of file owner. Mutually exclusive with `group_name`.",
-				Type:        schema.TypeString,
-				Optional:    true,
+			"backup": schema.BoolAttribute{
+				MarkdownDescription: "Copy the previous content of file to a timestamped sibling before it is overwritten. Defaults to `false`.",
+				Optional:            true,
+				Computed:            true,
+				Default:             booldefault.StaticBool(false),
 			},
-			"group_name": {
-				Description:   "Group name of file owner. Mutually exclusive with `group`.",
-				Type:          schema.TypeString,
-				Optional:      true,
-				ConflictsWith: []string{"group"},
+			"backup_suffix": schema.StringAttribute{
+				MarkdownDescription: "Suffix of backups, which are named `<path>.<timestamp><suffix>`. Defaults to `.bak`.",
+				Optional:            true,
+				Computed:            true,
+				Default:             stringdefault.StaticString(".bak"),
 			},
-			"owner": {
-				Description: "User ID (UID) of file owner. Mutually exclusive with `owner_name`.",
-				Type:        schema.TypeString,
-				Optional:    true,
+			"backup_keep": schema.Int64Attribute{
+				MarkdownDescription: "Number of backups to keep, older backups are removed. Zero keeps all backups. Defaults to `0`.",
+				Optional:            true,
+				Computed:            true,
+				Default:             int64default.StaticInt64(0),
 			},
-			"owner_name": {
-				Description:   "User name of file owner. Mutually exclusive with `owner`.",
-				Type:          schema.TypeString,
-				Optional:      true,
-				ConflictsWith: []string{"owner"},
+			"backup_path": schema.StringAttribute{
+				MarkdownDescription: "Path to the latest backup of file.",
+				Computed:            true,
+				PlanModifiers:       []planmodifier.String{stringplanmodifier.UseStateForUnknown()},
+			},
+			"store_content": schema.BoolAttribute{
+				MarkdownDescription: "Read the content of the remote file to detect changes to it. When `false`, changes to the remote file are instead detected by hashing it on the remote host, which avoids transferring large files, and `content` and `content_base64` in state hold the configured content. Defaults to `true`.",
+				Optional:            true,
+				Computed:            true,
+				Default:             booldefault.StaticBool(true),
+			},
+			"sha256": schema.StringAttribute{
+				MarkdownDescription: "SHA-256 hash of the content of file, in hex form.",
+				Computed:            true,
+				PlanModifiers:       []planmodifier.String{stringplanmodifier.UseStateForUnknown()},
+			},
+			"sha1": schema.StringAttribute{
+				MarkdownDescription: "SHA-1 hash of the content of file, in hex form.",
+				Computed:            true,
+				PlanModifiers:       []planmodifier.String{stringplanmodifier.UseStateForUnknown()},
+			},
+			"md5": schema.StringAttribute{
+				MarkdownDescription: "MD5 hash of the content of file, in hex form.",
+				Computed:            true,
+				PlanModifiers:       []planmodifier.String{stringplanmodifier.UseStateForUnknown()},
+			},
+			"size": schema.Int64Attribute{
+				MarkdownDescription: "Size of file in bytes.",
+				Computed:            true,
+				PlanModifiers:       []planmodifier.Int64{int64planmodifier.UseStateForUnknown()},
+			},
+			"host_key": schema.StringAttribute{
+				MarkdownDescription: "Public key presented by the remote host, in authorized keys format. Can be used as `host_key` in `conn` to pin the key of the remote host.",
+				Computed:            true,
+				PlanModifiers:       []planmodifier.String{stringplanmodifier.UseStateForUnknown()},
 			},
 		},
 	}
 }
 
-func resourceRemoteFileCreate(ctx context.Context, d *schema.ResourceData, meta interface{}) (error diag.Diagnostics) {
-	conn, err := meta.(*apiClient).getConnWithDefault(d)
-	if err != nil {
-		return diag.Diagnostics{{Severity: diag.Error, Summary: err.Error()}}
+func (r *remoteFileResource) Configure(ctx context.Context, req resource.ConfigureRequest, resp *resource.ConfigureResponse) {
+	// The provider is not configured yet when validating the configuration.
+	if req.ProviderData == nil {
+		return
 	}
+	r.client = req.ProviderData.(*apiClient)
+}
+
+// ValidateConfig validates `become`, which is otherwise only validated once
+// connecting to the remote host.
+func (r *remoteFileResource) ValidateConfig(ctx context.Context, req resource.ValidateConfigRequest, resp *resource.ValidateConfigResponse) {
+	var conn []connectionModel
+	resp.Diagnostics.Append(req.Config.GetAttribute(ctx, path.Root("conn"), &conn)...)
+	if resp.Diagnostics.HasError() || len(conn) == 0 || len(conn[0].Become) == 0 {
+		return
+	}
+
+	b := conn[0].Become[0]
+	if b.Method.IsUnknown() || b.Password.IsUnknown() {
+		return
+	}
+	become := become{method: stringOrDefault(b.Method, "sudo"), password: b.Password.ValueString()}
+	if err := become.validate(); err != nil {
+		resp.Diagnostics.AddAttributeError(path.Root("conn").AtListIndex(0).AtName("become"), "invalid become", err.Error())
+	}
+}
 
-	if err := setResourceID(d, conn); err != nil {
-		return diag.FromErr(err)
+// ModifyPlan plans new hashes, and a new backup when backups are enabled,
+// whenever the content changes.
+func (r *remoteFileResource) ModifyPlan(ctx context.Context, req resource.ModifyPlanRequest, resp *resource.ModifyPlanResponse) {
+	// Nothing is computed when the file is created or deleted.
+	if req.State.Raw.IsNull() || req.Plan.Raw.IsNull() {
+		return
 	}
 
-	client, err := meta.(*apiClient).getRemoteClient(ctx, conn)
+	var plan, state remoteFileResourceModel
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &plan)...)
+	resp.Diagnostics.Append(req.State.Get(ctx, &state)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	if !contentChanged(&plan, &state) {
+		return
+	}
+
+	plan.SHA256 = types.StringUnknown()
+	plan.SHA1 = types.StringUnknown()
+	plan.MD5 = types.StringUnknown()
+	plan.Size = types.Int64Unknown()
+	if !plan.Backup.Equal(types.BoolValue(false)) {
+		plan.BackupPath = types.StringUnknown()
+	}
+
+	resp.Diagnostics.Append(resp.Plan.Set(ctx, &plan)...)
+}
+
+// contentChanged returns whether the content planned in plan differs from
+// the content in state, which is nil when the file is created. When
+// `store_content` is false, the remote content is not read, so the planned
+// content is compared with the hash of the remote content instead. So is
+// content missing from state, which the SDK did not store when
+// `store_content` was false.
+func contentChanged(plan *remoteFileResourceModel, state *remoteFileResourceModel) bool {
+	if state == nil || !plan.Source.Equal(state.Source) {
+		return true
+	}
+	compareHashes := !plan.StoreContent.ValueBool()
+	return valueChanged(plan.Content, state.Content, state.SHA256, compareHashes, false) ||
+		valueChanged(plan.ContentBase64, state.ContentBase64, state.SHA256, compareHashes, true)
+}
+
+// valueChanged returns whether the planned value of `content` or
+// `content_base64` differs from the value in state, or from the hash in state
+// when compareHashes is true or the value in state is null.
+func valueChanged(plan types.String, state types.String, stateSHA256 types.String, compareHashes bool, isBase64 bool) bool {
+	if plan.IsUnknown() {
+		return true
+	}
+	if plan.IsNull() {
+		return !state.IsNull()
+	}
+	if !compareHashes && !state.IsNull() {
+		return !plan.Equal(state)
+	}
+
+	content := []byte(plan.ValueString())
+	if isBase64 {
+		decoded, err := base64.StdEncoding.DecodeString(plan.ValueString())
+		if err != nil {
+			return true
+		}
+		content = decoded
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(content)) != stateSHA256.ValueString()
+}
+
+func (r *remoteFileResource) Create(ctx context.Context, req resource.CreateRequest, resp *resource.CreateResponse) {
+	var plan remoteFileResourceModel
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &plan)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	conn, diags := r.connection(ctx, plan.Conn)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	client, err := r.client.getRemoteClient(ctx, conn)
 	if err != nil {
-		return diag.Errorf("unable to open remote client: %s", err.Error())
+		resp.Diagnostics.AddError("unable to open remote client", err.Error())
+		return
 	}
 	defer func() {
-		if err := meta.(*apiClient).closeRemoteClient(conn); err != nil {
-			error = append(error, diag.Errorf("unable to close remote client: %s", err.Error())...)
+		if err := r.client.closeRemoteClient(conn, client); err != nil {
+			resp.Diagnostics.AddError("unable to close remote client", err.Error())
 		}
 	}()
 
-	sudo, _, err := GetOk[bool](conn, "conn.0.sudo")
-	if err != nil {
-		return diag.Diagnostics{{Severity: diag.Error, Summary: err.Error()}}
+	resp.Diagnostics.Append(writeRemoteFile(ctx, client, conn, &plan, nil)...)
+	if resp.Diagnostics.HasError() {
+		return
 	}
 
-	content, err := Get[string](d, "content")
-	if err != nil {
-		return diag.Diagnostics{{Severity: diag.Error, Summary: err.Error()}}
+	resp.Diagnostics.Append(resp.State.Set(ctx, &plan)...)
+	resp.Diagnostics.Append(runCommandHook(ctx, client, "on_create_command", plan.OnCreateCommand, conn.sudo)...)
+}
+
+func (r *remoteFileResource) Update(ctx context.Context, req resource.UpdateRequest, resp *resource.UpdateResponse) {
+	var plan, state remoteFileResourceModel
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &plan)...)
+	resp.Diagnostics.Append(req.State.Get(ctx, &state)...)
+	if resp.Diagnostics.HasError() {
+		return
 	}
 
-	path, err := Get[string](d, "path")
-	if err != nil {
-		return diag.Diagnostics{{Severity: diag.Error, Summary: err.Error()}}
+	conn, diags := r.connection(ctx, plan.Conn)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
 	}
 
-	permissions, err := Get[string](d, "permissions")
+	client, err := r.client.getRemoteClient(ctx, conn)
 	if err != nil {
-		return diag.Diagnostics{{Severity: diag.Error, Summary: err.Error()}}
+		resp.Diagnostics.AddError("unable to open remote client", err.Error())
+		return
 	}
+	defer func() {
+		if err := r.client.closeRemoteClient(conn, client); err != nil {
+			resp.Diagnostics.AddError("unable to close remote client", err.Error())
+		}
+	}()
+
+	updated := contentChanged(&plan, &state) ||
+		!plan.Permissions.Equal(state.Permissions) ||
+		!plan.Group.Equal(state.Group) ||
+		!plan.GroupName.Equal(state.GroupName) ||
+		!plan.Owner.Equal(state.Owner) ||
+		!plan.OwnerName.Equal(state.OwnerName)
+
+	resp.Diagnostics.Append(writeRemoteFile(ctx, client, conn, &plan, &state)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	resp.Diagnostics.Append(resp.State.Set(ctx, &plan)...)
+
+	if updated {
+		resp.Diagnostics.Append(runCommandHook(ctx, client, "on_update_command", plan.OnUpdateCommand, conn.sudo)...)
+	}
+}
+
+// writeRemoteFile writes the content in plan to the remote file when it
+// differs from the content in state, which is nil when the file is created,
+// and sets the permissions and ownership of the file. The values computed
+// when writing are set in plan.
+func writeRemoteFile(ctx context.Context, client *RemoteClient, conn *connection, plan *remoteFileResourceModel, state *remoteFileResourceModel) diag.Diagnostics {
+	var diags diag.Diagnostics
+	sudo := conn.sudo
 
-	var group string
-	if g, ok, err := GetOk[string](d, "group"); ok {
+	path := plan.Path.ValueString()
+	permissions := plan.Permissions.ValueString()
+
+	group := plan.Group.ValueString()
+	if group == "" {
+		group = plan.GroupName.ValueString()
+	}
+
+	owner := plan.Owner.ValueString()
+	if owner == "" {
+		owner = plan.OwnerName.ValueString()
+	}
+
+	atomic := plan.Atomic.ValueBool()
+	validateCommand := plan.ValidateCommand.ValueString()
+
+	if contentChanged(plan, state) || plan.SHA256.IsUnknown() {
+		content, err := plan.content()
 		if err != nil {
-			return diag.Diagnostics{{Severity: diag.Error, Summary: err.Error()}}
+			diags.AddError("unable to read content", err.Error())
+			return diags
 		}
-		group = g
-	} else if g, ok, err := GetOk[string](d, "group_name"); ok {
-		if err != nil {
-			return diag.Diagnostics{{Severity: diag.Error, Summary: err.Error()}}
+
+		if validateCommand != "" && !atomic {
+			if err := client.ValidateContent(ctx, content, path, permissions, owner, group, validateCommand, sudo); err != nil {
+				diags.AddError("remote file failed validation", err.Error())
+				return diags
+			}
+		}
+
+		if plan.Backup.ValueBool() {
+			backupPath, err := backupRemoteFile(ctx, client, path, plan.BackupSuffix.ValueString(), int(plan.BackupKeep.ValueInt64()), sudo)
+			if err != nil {
+				diags.AddError("unable to backup remote file", err.Error())
+				return diags
+			}
+			if backupPath != "" {
+				plan.BackupPath = types.StringValue(backupPath)
+			}
 		}
-		group = g
-	}
 
-	var owner string
-	if o, ok, err := GetOk[string](d, "owner"); ok {
+		if atomic {
+			err = client.WriteFileAtomic(ctx, content, path, permissions, owner, group, validateCommand, sudo)
+		} else {
+			err = client.WriteFile(ctx, content, path, permissions, sudo)
+		}
+		var validationErr ValidationError
+		if errors.As(err, &validationErr) {
+			diags.AddError("remote file failed validation", err.Error())
+			return diags
+		}
 		if err != nil {
-			return diag.Diagnostics{{Severity: diag.Error, Summary: err.Error()}}
+			diags.AddError("unable to create remote file", err.Error())
+			return diags
 		}
-		owner = o
-	} else if o, ok, err := GetOk[string](d, "owner_name"); ok {
+
+		hashes, err := hashContent(strings.NewReader(content))
 		if err != nil {
-			return diag.Diagnostics{{Severity: diag.Error, Summary: err.Error()}}
+			diags.AddError("unable to hash content", err.Error())
+			return diags
 		}
-		owner = o
+		plan.setHashes(hashes)
 	}
 
-	if d.HasChange("content") {
-		if err := client.WriteFile(ctx, content, path, permissions, sudo); err != nil {
-			return diag.Errorf("unable to create remote file: %s", err.Error())
+	// The previous backup is kept when there was nothing to backup.
+	if plan.BackupPath.IsUnknown() {
+		if state != nil {
+			plan.BackupPath = types.StringValue(state.BackupPath.ValueString())
+		} else {
+			plan.BackupPath = types.StringValue("")
 		}
 	}
 
-	if err := client.ChmodFile(path, permissions, sudo); err != nil {
-		return diag.Errorf("unable to change permissions of remote file: %s", err.Error())
+	if err := client.ChmodFile(ctx, path, permissions, sudo); err != nil {
+		diags.AddError("unable to change permissions of remote file", err.Error())
+		return diags
 	}
 
 	if group != "" {
-		if err := client.ChgrpFile(path, group, sudo); err != nil {
-			return diag.Errorf("unable to change group of remote file: %s", err.Error())
+		if err := client.ChgrpFile(ctx, path, group, sudo); err != nil {
+			diags.AddError("unable to change group of remote file", err.Error())
+			return diags
 		}
 	}
 
 	if owner != "" {
-		if err := client.ChownFile(path, owner, sudo); err != nil {
-			return diag.Errorf("unable to change owner of remote file: %s", err.Error())
+		if err := client.ChownFile(ctx, path, owner, sudo); err != nil {
+			diags.AddError("unable to change owner of remote file", err.Error())
+			return diags
 		}
 	}
 
-	return diag.Diagnostics{}
+	plan.ID = types.StringValue(resourceID{host: conn.host, port: conn.port, path: path}.String())
+	plan.HostKey = types.StringValue(marshalHostKey(client.HostKey()))
+
+	return diags
 }
 
-func resourceRemoteFileRead(ctx context.Context, d *schema.ResourceData, meta interface{}) (error diag.Diagnostics) {
-	conn, err := meta.(*apiClient).getConnWithDefault(d)
+// content returns the content to write to the remote file, from whichever
+// of `content`, `content_base64` and `source` is set.
+func (m *remoteFileResourceModel) content() (string, error) {
+	if !m.ContentBase64.IsNull() {
+		content, err := base64.StdEncoding.DecodeString(m.ContentBase64.ValueString())
+		if err != nil {
+			return "", fmt.Errorf("unable to decode content_base64: %s", err.Error())
+		}
+		return string(content), nil
+	}
+
+	if !m.Source.IsNull() {
+		content, err := os.ReadFile(m.Source.ValueString())
+		if err != nil {
+			return "", fmt.Errorf("unable to read source: %s", err.Error())
+		}
+		return string(content), nil
+	}
+
+	return m.Content.ValueString(), nil
+}
+
+func (m *remoteFileResourceModel) setHashes(hashes FileHashes) {
+	m.SHA256 = types.StringValue(hashes.SHA256)
+	m.SHA1 = types.StringValue(hashes.SHA1)
+	m.MD5 = types.StringValue(hashes.MD5)
+	m.Size = types.Int64Value(hashes.Size)
+}
+
+// runCommandHook runs cmd, if set. The output of the command is returned as
+// a warning, or as part of the error if the command fails.
+func runCommandHook(ctx context.Context, client *RemoteClient, key string, cmd types.String, sudo bool) diag.Diagnostics {
+	var diags diag.Diagnostics
+	if cmd.IsNull() {
+		return diags
+	}
+
+	stdout, stderr, err := client.RunCommand(ctx, cmd.ValueString(), sudo)
+	detail := commandOutput(stdout, stderr)
 	if err != nil {
-		return diag.FromErr(err)
+		diags.AddError(fmt.Sprintf("%s failed: %s", key, err.Error()), detail)
+	} else if detail != "" {
+		diags.AddWarning(fmt.Sprintf("%s output", key), detail)
 	}
+	return diags
+}
 
-	if err := setResourceID(d, conn); err != nil {
-		return diag.FromErr(err)
+// ImportState imports the file in the ID, which is on the host of the `conn`
+// of the provider, as imported resources have no `conn` of their own.
+func (r *remoteFileResource) ImportState(ctx context.Context, req resource.ImportStateRequest, resp *resource.ImportStateResponse) {
+	id, err := parseResourceID(req.ID)
+	if err != nil {
+		resp.Diagnostics.AddError("unable to import file", err.Error())
+		return
 	}
 
-	client, err := meta.(*apiClient).getRemoteClient(ctx, conn)
+	conn, err := r.client.connWithDefault(nil)
 	if err != nil {
-		return diag.Errorf("unable to open remote client: %s", err.Error())
+		resp.Diagnostics.AddError("unable to import file", fmt.Sprintf("the provider has no conn to connect to %s:%d with", id.host, id.port))
+		return
+	}
+	if err := matchResourceID(conn, id); err != nil {
+		resp.Diagnostics.AddError("unable to import file", err.Error())
+		return
+	}
+
+	client, err := r.client.getRemoteClient(ctx, conn)
+	if err != nil {
+		resp.Diagnostics.AddError("unable to open remote client", err.Error())
+		return
 	}
 	defer func() {
-		if err := meta.(*apiClient).closeRemoteClient(conn); err != nil {
-			error = append(error, diag.Errorf("unable to close remote client: %s", err.Error())...)
+		if err := r.client.closeRemoteClient(conn, client); err != nil {
+			resp.Diagnostics.AddError("unable to close remote client", err.Error())
 		}
 	}()
 
-	sudo, _, err := GetOk[bool](conn, "conn.0.sudo")
+	info, err := client.Stat(ctx, id.path, conn.sudo)
 	if err != nil {
-		return diag.Diagnostics{{Severity: diag.Error, Summary: err.Error()}}
+		resp.Diagnostics.AddError("unable to stat remote file", err.Error())
+		return
+	}
+	if !info.Mode.IsRegular() {
+		resp.Diagnostics.AddError("unable to import file", fmt.Sprintf("%s is not a regular file", id.path))
+		return
 	}
 
-	path, err := Get[string](d, "path")
-	if err != nil {
-		return diag.Diagnostics{{Severity: diag.Error, Summary: err.Error()}}
+	// Defaults are not set when importing, but must be in state for the
+	// configuration generated from it to plan no changes. The content is read
+	// once store_content is set.
+	state := remoteFileResourceModel{
+		ID:           types.StringValue(resourceID{host: conn.host, port: conn.port, path: id.path}.String()),
+		Path:         types.StringValue(id.path),
+		Permissions:  types.StringValue(info.Permissions()),
+		Atomic:       types.BoolValue(false),
+		Backup:       types.BoolValue(false),
+		BackupSuffix: types.StringValue(".bak"),
+		BackupKeep:   types.Int64Value(0),
+		BackupPath:   types.StringValue(""),
+		StoreContent: types.BoolValue(true),
 	}
 
-	_, groupOk, err := GetOk[string](d, "group")
-	if err != nil {
-		return diag.Diagnostics{{Severity: diag.Error, Summary: err.Error()}}
+	// Either the name or the ID of the owner and group is set, to generate a
+	// valid configuration. Names are preferred, unless the remote host is
+	// unable to resolve them. The ownership is refreshed by read once set.
+	if isResolvedName(info.UserName) {
+		state.OwnerName = types.StringValue(info.UserName)
+	} else {
+		state.Owner = types.StringValue(strconv.Itoa(info.UID))
+	}
+	if isResolvedName(info.GroupName) {
+		state.GroupName = types.StringValue(info.GroupName)
+	} else {
+		state.Group = types.StringValue(strconv.Itoa(info.GID))
 	}
 
-	_, groupNameOk, err := GetOk[string](d, "group_name")
-	if err != nil {
-		return diag.Diagnostics{{Severity: diag.Error, Summary: err.Error()}}
+	resp.Diagnostics.Append(resp.State.Set(ctx, &state)...)
+}
+
+// isResolvedName returns whether name is a user or group name resolved by
+// stat, which prints UNKNOWN for IDs without a name.
+func isResolvedName(name string) bool {
+	if name == "" || name == "UNKNOWN" {
+		return false
 	}
+	_, err := strconv.Atoi(name)
+	return err != nil
+}
 
-	_, ownerOk, err := GetOk[string](d, "owner")
-	if err != nil {
-		return diag.Diagnostics{{Severity: diag.Error, Summary: err.Error()}}
+func (r *remoteFileResource) Read(ctx context.Context, req resource.ReadRequest, resp *resource.ReadResponse) {
+	var state remoteFileResourceModel
+	resp.Diagnostics.Append(req.State.Get(ctx, &state)...)
+	if resp.Diagnostics.HasError() {
+		return
 	}
 
-	_, ownerNameOk, err := GetOk[string](d, "owner_name")
-	if err != nil {
-		return diag.Diagnostics{{Severity: diag.Error, Summary: err.Error()}}
+	conn, diags := r.connection(ctx, state.Conn)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
 	}
 
-	exists, err := client.FileExists(path, sudo)
+	client, err := r.client.getRemoteClient(ctx, conn)
 	if err != nil {
-		return diag.Errorf("unable to check if remote file exists: %s", err.Error())
+		resp.Diagnostics.AddError("unable to open remote client", err.Error())
+		return
 	}
-	if exists {
-		content, err := client.ReadFile(path, sudo)
-		if err != nil {
-			return diag.Errorf("unable to read remote file: %s", err.Error())
-		}
-		if err := d.Set("content", content); err != nil {
-			return diag.FromErr(err)
+	defer func() {
+		if err := r.client.closeRemoteClient(conn, client); err != nil {
+			resp.Diagnostics.AddError("unable to close remote client", err.Error())
 		}
+	}()
+
+	sudo := conn.sudo
+	path := state.Path.ValueString()
 
-		permissions, err := client.ReadFilePermissions(path, sudo)
+	state.ID = types.StringValue(resourceID{host: conn.host, port: conn.port, path: path}.String())
+	state.HostKey = types.StringValue(marshalHostKey(client.HostKey()))
+
+	info, err := client.Stat(ctx, path, sudo)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		resp.Diagnostics.AddError("unable to stat remote file", err.Error())
+		return
+	}
+	if err != nil || !info.Mode.IsRegular() {
+		resp.State.RemoveResource(ctx)
+		return
+	}
+
+	var hashes FileHashes
+	if state.StoreContent.ValueBool() && state.Source.IsNull() {
+		content, err := client.ReadFile(ctx, path, sudo)
 		if err != nil {
-			return diag.Errorf("unable to read remote file permissions: %s", err.Error())
+			resp.Diagnostics.AddError("unable to read remote file", err.Error())
+			return
 		}
-		if err := d.Set("permissions", permissions); err != nil {
-			return diag.FromErr(err)
+		// Imported files have neither, and binary content is stored as
+		// base64 as it is not valid in Terraform strings.
+		if !state.ContentBase64.IsNull() || (state.Content.IsNull() && !utf8.ValidString(content)) {
+			state.ContentBase64 = types.StringValue(base64.StdEncoding.EncodeToString([]byte(content)))
+		} else {
+			state.Content = types.StringValue(content)
 		}
 
-		if ownerOk {
-			owner, err := client.ReadFileOwner(path, sudo)
-			if err != nil {
-				return diag.Errorf("unable to read remote file owner: %s", err.Error())
-			}
-			if err := d.Set("owner", owner); err != nil {
-				return diag.FromErr(err)
-			}
-		}
-		if ownerNameOk {
-			ownerName, err := client.ReadFileOwnerName(path, sudo)
-			if err != nil {
-				return diag.Errorf("unable to read remote file owner_name: %s", err.Error())
-			}
-			if err := d.Set("owner_name", ownerName); err != nil {
-				return diag.FromErr(err)
-			}
+		hashes, err = hashContent(strings.NewReader(content))
+		if err != nil {
+			resp.Diagnostics.AddError("unable to hash content", err.Error())
+			return
 		}
-
-		if groupOk {
-			group, err := client.ReadFileGroup(path, sudo)
-			if err != nil {
-				return diag.Errorf("unable to read remote file group: %s", err.Error())
-			}
-			if err := d.Set("group", group); err != nil {
-				return diag.FromErr(err)
-			}
+	} else {
+		hashes, err = client.ReadFileHashes(ctx, path, sudo)
+		if err != nil {
+			resp.Diagnostics.AddError("unable to hash remote file", err.Error())
+			return
 		}
-		if groupNameOk {
-			groupName, err := client.ReadFileGroupName(path, sudo)
-			if err != nil {
-				return diag.Errorf("unable to read remote file group_name: %s", err.Error())
-			}
-			if err := d.Set("group_name", groupName); err != nil {
-				return diag.FromErr(err)
-			}
+	}
+	state.setHashes(hashes)
+
+	if !state.Source.IsNull() {
+		// The content of source is not stored in state. Unset source when
+		// the remote content differs from the local content, to make
+		// Terraform plan an update.
+		local, err := os.ReadFile(state.Source.ValueString())
+		if err != nil || fmt.Sprintf("%x", sha256.Sum256(local)) != hashes.SHA256 {
+			state.Source = types.StringNull()
 		}
-	} else {
-		d.SetId("")
 	}
 
-	return diag.Diagnostics{}
-}
+	state.Permissions = types.StringValue(info.Permissions())
+
+	if !state.Owner.IsNull() {
+		state.Owner = types.StringValue(strconv.Itoa(info.UID))
+	}
+	if !state.OwnerName.IsNull() {
+		state.OwnerName = types.StringValue(info.UserName)
+	}
+	if !state.Group.IsNull() {
+		state.Group = types.StringValue(strconv.Itoa(info.GID))
+	}
+	if !state.GroupName.IsNull() {
+		state.GroupName = types.StringValue(info.GroupName)
+	}
 
-func resourceRemoteFileUpdate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
-	return resourceRemoteFileCreate(ctx, d, meta)
+	resp.Diagnostics.Append(resp.State.Set(ctx, &state)...)
 }
 
-func resourceRemoteFileDelete(ctx context.Context, d *schema.ResourceData, meta interface{}) (error diag.Diagnostics) {
-	conn, err := meta.(*apiClient).getConnWithDefault(d)
-	if err != nil {
-		return diag.Diagnostics{{Severity: diag.Error, Summary: err.Error()}}
+func (r *remoteFileResource) Delete(ctx context.Context, req resource.DeleteRequest, resp *resource.DeleteResponse) {
+	var state remoteFileResourceModel
+	resp.Diagnostics.Append(req.State.Get(ctx, &state)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	conn, diags := r.connection(ctx, state.Conn)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
 	}
 
-	client, err := meta.(*apiClient).getRemoteClient(ctx, conn)
+	client, err := r.client.getRemoteClient(ctx, conn)
 	if err != nil {
-		return diag.Errorf("unable to open remote client: %s", err.Error())
+		resp.Diagnostics.AddError("unable to open remote client", err.Error())
+		return
 	}
 	defer func() {
-		if err := meta.(*apiClient).closeRemoteClient(conn); err != nil {
-			error = append(error, diag.Errorf("unable to close remote client: %s", err.Error())...)
+		if err := r.client.closeRemoteClient(conn, client); err != nil {
+			resp.Diagnostics.AddError("unable to close remote client", err.Error())
 		}
 	}()
 
-	sudo, _, err := GetOk[bool](conn, "conn.0.sudo")
+	sudo := conn.sudo
+	path := state.Path.ValueString()
+
+	exists, err := client.FileExists(ctx, path, sudo)
 	if err != nil {
-		return diag.Diagnostics{{Severity: diag.Error, Summary: err.Error()}}
+		resp.Diagnostics.AddError("unable to check if remote file exists", err.Error())
+		return
+	}
+	if exists {
+		if err := client.DeleteFile(ctx, path, sudo); err != nil {
+			resp.Diagnostics.AddError("unable to delete remote file", err.Error())
+			return
+		}
+		resp.Diagnostics.Append(runCommandHook(ctx, client, "on_destroy_command", state.OnDestroyCommand, sudo)...)
 	}
+}
 
-	path, err := Get[string](d, "path")
+// connection returns the connection in the `conn` block, or the `conn` of the
+// provider when the block is not set.
+func (r *remoteFileResource) connection(ctx context.Context, conn []connectionModel) (*connection, diag.Diagnostics) {
+	c, diags := connectionFromModel(ctx, conn)
+	if diags.HasError() {
+		return nil, diags
+	}
+	c, err := r.client.connWithDefault(c)
 	if err != nil {
-		return diag.Diagnostics{{Severity: diag.Error, Summary: err.Error()}}
+		diags.AddError("unable to connect", err.Error())
 	}
+	return c, diags
+}
 
-	exists, err := client.FileExists(path, sudo)
-	if err != nil {
-		return diag.Errorf("unable to check if remote file exists: %s", err.Error())
+// UpgradeState upgrades state written by the SDK, in which strings and
+// numbers that were not set are their zero value rather than null.
+func (r *remoteFileResource) UpgradeState(ctx context.Context) map[int64]resource.StateUpgrader {
+	priorSchema := remoteFileResourceSchema()
+	return map[int64]resource.StateUpgrader{
+		0: {
+			PriorSchema: &priorSchema,
+			StateUpgrader: func(ctx context.Context, req resource.UpgradeStateRequest, resp *resource.UpgradeStateResponse) {
+				var state remoteFileResourceModel
+				resp.Diagnostics.Append(req.State.Get(ctx, &state)...)
+				if resp.Diagnostics.HasError() {
+					return
+				}
+				state.nullZeroValues()
+				resp.Diagnostics.Append(resp.State.Set(ctx, &state)...)
+			},
+		},
 	}
-	if exists {
-		if err := client.DeleteFile(path, sudo); err != nil {
-			return diag.Errorf("unable to delete remote file: %s", err.Error())
+}
+
+// nullZeroValues sets the attributes that the SDK stored as their zero value
+// when not set to null, as they are null in the configuration.
+func (m *remoteFileResourceModel) nullZeroValues() {
+	for i := range m.Conn {
+		m.Conn[i].nullZeroValues()
+	}
+
+	for _, attribute := range []*types.String{
+		&m.Source,
+		&m.Group,
+		&m.GroupName,
+		&m.Owner,
+		&m.OwnerName,
+		&m.ValidateCommand,
+		&m.OnCreateCommand,
+		&m.OnUpdateCommand,
+		&m.OnDestroyCommand,
+	} {
+		*attribute = nullStringIfEmpty(*attribute)
+	}
+
+	// Exactly one of the content attributes is set, and empty content is
+	// valid.
+	m.ContentBase64 = nullStringIfEmpty(m.ContentBase64)
+	if !m.ContentBase64.IsNull() || !m.Source.IsNull() {
+		m.Content = types.StringNull()
+	}
+}
+
+// backupTimeFormat is the format of the timestamp in the names of backups,
+// which sorts backups from oldest to newest. Nanoseconds keep backups written
+// within the same second apart.
+const backupTimeFormat = "20060102T150405.000000000Z"
+
+// backupRemoteFile copies the remote file to a timestamped sibling, if it
+// exists, and removes the oldest backups beyond keep. It returns the path to
+// the backup, or an empty string when there was nothing to backup.
+func backupRemoteFile(ctx context.Context, client *RemoteClient, path string, suffix string, keep int, sudo bool) (string, error) {
+	exists, err := client.FileExists(ctx, path, sudo)
+	if err != nil || !exists {
+		return "", err
+	}
+
+	backupPath := fmt.Sprintf("%s.%s%s", path, time.Now().UTC().Format(backupTimeFormat), suffix)
+	if err := client.CopyFile(ctx, path, backupPath, sudo); err != nil {
+		return "", err
+	}
+
+	if keep > 0 {
+		dir, name := pathpkg.Split(path)
+		if dir == "" {
+			dir = "."
+		}
+		names, err := client.ReadDirectory(ctx, dir, sudo)
+		if err != nil {
+			return "", err
+		}
+
+		backups := []string{}
+		for _, backup := range names {
+			timestamp, ok := strings.CutPrefix(backup, name+".")
+			if !ok {
+				continue
+			}
+			timestamp, ok = strings.CutSuffix(timestamp, suffix)
+			if !ok {
+				continue
+			}
+			if _, err := time.Parse(backupTimeFormat, timestamp); err == nil {
+				backups = append(backups, backup)
+			}
+		}
+		sort.Strings(backups)
+
+		for len(backups) > keep {
+			if err := client.DeleteFile(ctx, pathpkg.Join(dir, backups[0]), sudo); err != nil {
+				return "", err
+			}
+			backups = backups[1:]
 		}
 	}
 
-	return diag.Diagnostics{}
+	return backupPath, nil
+}
+
+// base64Validator validates that strings are base64 encoded.
+type base64Validator struct{}
+
+func (v base64Validator) Description(ctx context.Context) string {
+	return "value must be base64 encoded"
+}
+
+func (v base64Validator) MarkdownDescription(ctx context.Context) string {
+	return v.Description(ctx)
+}
+
+func (v base64Validator) ValidateString(ctx context.Context, req validator.StringRequest, resp *validator.StringResponse) {
+	if req.ConfigValue.IsNull() || req.ConfigValue.IsUnknown() {
+		return
+	}
+	if _, err := base64.StdEncoding.DecodeString(req.ConfigValue.ValueString()); err != nil {
+		resp.Diagnostics.AddAttributeError(req.Path, "invalid base64", fmt.Sprintf("%s must be base64 encoded: %s", req.Path, err.Error()))
+	}
 }
@@ -1,17 +1,23 @@
 package provider
 
 import (
+	"context"
+	"fmt"
 	"os"
+	"path/filepath"
 	"regexp"
 	"testing"
 
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-go/tfprotov5"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
 )
 
 func TestAccResourceRemoteFile(t *testing.T) {
 	resource.UnitTest(t, resource.TestCase{
-		PreCheck:          func() { testAccPreCheck(t) },
-		ProviderFactories: providerFactories,
+		PreCheck:                 func() { testAccPreCheck(t) },
+		ProtoV5ProviderFactories: protoV5ProviderFactories,
 		Steps: []resource.TestStep{
 			{
 				Config: `
@@ -39,8 +45,8 @@ func TestAccResourceRemoteFile(t *testing.T) {
 
 func TestAccResourceRemoteFileWithDefaultConnection(t *testing.T) {
 	resource.UnitTest(t, resource.TestCase{
-		PreCheck:          func() { testAccPreCheck(t) },
-		ProviderFactories: providerFactories,
+		PreCheck:                 func() { testAccPreCheck(t) },
+		ProtoV5ProviderFactories: protoV5ProviderFactories,
 		Steps: []resource.TestStep{
 			{
 				Config: `
@@ -68,8 +74,8 @@ func TestAccResourceRemoteFileWithAgent(t *testing.T) {
 	}
 
 	resource.UnitTest(t, resource.TestCase{
-		PreCheck:          func() { testAccPreCheck(t) },
-		ProviderFactories: providerFactories,
+		PreCheck:                 func() { testAccPreCheck(t) },
+		ProtoV5ProviderFactories: protoV5ProviderFactories,
 		Steps: []resource.TestStep{
 			{
 				Config: `
@@ -94,8 +100,8 @@ func TestAccResourceRemoteFileWithAgent(t *testing.T) {
 
 func TestAccResourceRemoteFileOwnership(t *testing.T) {
 	resource.UnitTest(t, resource.TestCase{
-		PreCheck:          func() { testAccPreCheck(t) },
-		ProviderFactories: providerFactories,
+		PreCheck:                 func() { testAccPreCheck(t) },
+		ProtoV5ProviderFactories: protoV5ProviderFactories,
 		Steps: []resource.TestStep{
 			{
 				Config: `
@@ -114,10 +120,10 @@ func TestAccResourceRemoteFileOwnership(t *testing.T) {
 				}
 				`,
 				Check: resource.ComposeTestCheckFunc(
-					resource.TestMatchResourceAttr(
-						"remote_file.resource_4", "owner_name", regexp.MustCompile("")),
-					resource.TestMatchResourceAttr(
-						"remote_file.resource_4", "group_name", regexp.MustCompile("")),
+					resource.TestCheckNoResourceAttr(
+						"remote_file.resource_4", "owner_name"),
+					resource.TestCheckNoResourceAttr(
+						"remote_file.resource_4", "group_name"),
 					resource.TestMatchResourceAttr(
 						"remote_file.resource_4", "owner", regexp.MustCompile("1000")),
 					resource.TestMatchResourceAttr(
@@ -130,8 +136,8 @@ func TestAccResourceRemoteFileOwnership(t *testing.T) {
 
 func TestAccResourceRemoteFileOwnershipWithDefaultConnection(t *testing.T) {
 	resource.UnitTest(t, resource.TestCase{
-		PreCheck:          func() { testAccPreCheck(t) },
-		ProviderFactories: providerFactories,
+		PreCheck:                 func() { testAccPreCheck(t) },
+		ProtoV5ProviderFactories: protoV5ProviderFactories,
 		Steps: []resource.TestStep{
 			{
 				Config: `
@@ -144,10 +150,10 @@ func TestAccResourceRemoteFileOwnershipWithDefaultConnection(t *testing.T) {
 				}
 				`,
 				Check: resource.ComposeTestCheckFunc(
-					resource.TestMatchResourceAttr(
-						"remote_file.resource_5", "owner_name", regexp.MustCompile("")),
-					resource.TestMatchResourceAttr(
-						"remote_file.resource_5", "group_name", regexp.MustCompile("")),
+					resource.TestCheckNoResourceAttr(
+						"remote_file.resource_5", "owner_name"),
+					resource.TestCheckNoResourceAttr(
+						"remote_file.resource_5", "group_name"),
 					resource.TestMatchResourceAttr(
 						"remote_file.resource_5", "owner", regexp.MustCompile("1000")),
 					resource.TestMatchResourceAttr(
@@ -160,8 +166,8 @@ func TestAccResourceRemoteFileOwnershipWithDefaultConnection(t *testing.T) {
 
 func TestAccResourceRemoteFileOwnershipNames(t *testing.T) {
 	resource.UnitTest(t, resource.TestCase{
-		PreCheck:          func() { testAccPreCheck(t) },
-		ProviderFactories: providerFactories,
+		PreCheck:                 func() { testAccPreCheck(t) },
+		ProtoV5ProviderFactories: protoV5ProviderFactories,
 		Steps: []resource.TestStep{
 			{
 				Config: `
@@ -184,12 +190,955 @@ func TestAccResourceRemoteFileOwnershipNames(t *testing.T) {
 						"remote_file.resource_6", "owner_name", regexp.MustCompile("root")),
 					resource.TestMatchResourceAttr(
 						"remote_file.resource_6", "group_name", regexp.MustCompile("root")),
+					resource.TestCheckNoResourceAttr(
+						"remote_file.resource_6", "owner"),
+					resource.TestCheckNoResourceAttr(
+						"remote_file.resource_6", "group"),
+				),
+			},
+		},
+	})
+}
+
+func TestAccResourceRemoteFileKnownHosts(t *testing.T) {
+	knownHostsPath := filepath.Join(t.TempDir(), "known_hosts")
+
+	resource.UnitTest(t, resource.TestCase{
+		PreCheck:                 func() { testAccPreCheck(t) },
+		ProtoV5ProviderFactories: protoV5ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				// Add 'remotehost' to the empty known hosts file
+				Config: fmt.Sprintf(`
+				resource "remote_file" "resource_7" {
+					conn {
+						host = "remotehost"
+						user = "root"
+						password = "password"
+						known_hosts_path = "%s"
+						strict_host_key_checking = "accept-new"
+					}
+					path = "/tmp/resource_7.txt"
+					content = "resource_7"
+				}
+				`, knownHostsPath),
+				Check: resource.ComposeTestCheckFunc(
 					resource.TestMatchResourceAttr(
-						"remote_file.resource_6", "owner", regexp.MustCompile("")),
+						"remote_file.resource_7", "host_key", regexp.MustCompile("^ssh-")),
+				),
+			},
+			{
+				// Verify 'remotehost' against the known hosts file
+				Config: fmt.Sprintf(`
+				resource "remote_file" "resource_7" {
+					conn {
+						host = "remotehost"
+						user = "root"
+						password = "password"
+						known_hosts_path = "%s"
+					}
+					path = "/tmp/resource_7.txt"
+					content = "resource_7"
+				}
+				`, knownHostsPath),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestMatchResourceAttr(
+						"remote_file.resource_7", "content", regexp.MustCompile("resource_7")),
+				),
+			},
+		},
+	})
+}
+
+func TestAccResourceRemoteFileHostKeyMismatch(t *testing.T) {
+	resource.UnitTest(t, resource.TestCase{
+		PreCheck:                 func() { testAccPreCheck(t) },
+		ProtoV5ProviderFactories: protoV5ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: `
+				resource "remote_file" "resource_8" {
+					conn {
+						host = "remotehost"
+						user = "root"
+						password = "password"
+						host_key = file("../../tests/key.pub")
+					}
+					path = "/tmp/resource_8.txt"
+					content = "resource_8"
+				}
+				`,
+				ExpectError: regexp.MustCompile("host key mismatch"),
+			},
+		},
+	})
+}
+
+func TestAccResourceRemoteFileProxyJump(t *testing.T) {
+	resource.UnitTest(t, resource.TestCase{
+		PreCheck:                 func() { testAccPreCheck(t) },
+		ProtoV5ProviderFactories: protoV5ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: `
+				resource "remote_file" "resource_9" {
+					conn {
+						host = "remotehost2"
+						user = "root"
+						password = "password"
+						proxy_jump {
+							host = "remotehost"
+							user = "root"
+							password = "password"
+						}
+					}
+					path = "/tmp/resource_9.txt"
+					content = "resource_9"
+				}
+
+				resource "remote_file" "resource_10" {
+					conn {
+						host = "remotehost"
+						user = "root"
+						password = "password"
+						proxy_jump {
+							host = "remotehost"
+							user = "root"
+							password = "password"
+						}
+						proxy_jump {
+							host = "remotehost2"
+							user = "root"
+							password = "password"
+						}
+					}
+					path = "/tmp/resource_10.txt"
+					content = "resource_10"
+				}
+				`,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestMatchResourceAttr(
+						"remote_file.resource_9", "content", regexp.MustCompile("resource_9")),
+					resource.TestMatchResourceAttr(
+						"remote_file.resource_10", "content", regexp.MustCompile("resource_10")),
+				),
+			},
+		},
+	})
+}
+
+func TestAccResourceRemoteFileContentBase64(t *testing.T) {
+	resource.UnitTest(t, resource.TestCase{
+		PreCheck:                 func() { testAccPreCheck(t) },
+		ProtoV5ProviderFactories: protoV5ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: `
+				resource "remote_file" "resource_11" {
+					provider = remotehost
+					path = "/tmp/resource_11.bin"
+					content_base64 = "AAH+/w=="
+				}
+
+				data "remote_file" "resource_11" {
+					provider = remotehost
+					path = remote_file.resource_11.path
+				}
+				`,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestMatchResourceAttr(
+						"remote_file.resource_11", "content_base64", regexp.MustCompile(`^AAH\+/w==$`)),
+					resource.TestMatchResourceAttr(
+						"data.remote_file.resource_11", "content_base64", regexp.MustCompile(`^AAH\+/w==$`)),
+				),
+			},
+		},
+	})
+}
+
+func TestAccResourceRemoteFileSource(t *testing.T) {
+	source := filepath.Join(t.TempDir(), "source.txt")
+	if err := os.WriteFile(source, []byte("resource_12"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config := fmt.Sprintf(`
+	resource "remote_file" "resource_12" {
+		provider = remotehost
+		path = "/tmp/resource_12.txt"
+		source = "%s"
+	}
+
+	data "remote_file" "resource_12" {
+		provider = remotehost
+		path = remote_file.resource_12.path
+	}
+	`, source)
+
+	resource.UnitTest(t, resource.TestCase{
+		PreCheck:                 func() { testAccPreCheck(t) },
+		ProtoV5ProviderFactories: protoV5ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: config,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestMatchResourceAttr(
+						"data.remote_file.resource_12", "content", regexp.MustCompile("^resource_12$")),
+				),
+			},
+			{
+				// Modify the local source, which must be copied to the remote file
+				PreConfig: func() {
+					if err := os.WriteFile(source, []byte("resource_12_modified"), 0644); err != nil {
+						t.Fatal(err)
+					}
+				},
+				Config: config,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestMatchResourceAttr(
+						"data.remote_file.resource_12", "content", regexp.MustCompile("^resource_12_modified$")),
+				),
+			},
+		},
+	})
+}
+
+func TestAccResourceRemoteFileHashes(t *testing.T) {
+	config := `
+	resource "remote_file" "resource_13" {
+		provider = remotehost
+		path = "/tmp/resource_13.txt"
+		content = "resource_13"
+		store_content = false
+	}
+
+	data "remote_file" "resource_13" {
+		provider = remotehost
+		path = remote_file.resource_13.path
+	}
+	`
+
+	emptyConfig := `
+	resource "remote_file" "resource_13" {
+		provider = remotehost
+		path = "/tmp/resource_13.txt"
+		content = ""
+		store_content = false
+	}
+	`
+
+	resource.UnitTest(t, resource.TestCase{
+		PreCheck:                 func() { testAccPreCheck(t) },
+		ProtoV5ProviderFactories: protoV5ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: config,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr(
+						"remote_file.resource_13", "content", "resource_13"),
+					resource.TestCheckResourceAttr(
+						"remote_file.resource_13", "sha256", "458d57f1b3576125be2f8322017c416a3865a343e58aa497a70f219bb99f2bb5"),
+					resource.TestCheckResourceAttr(
+						"remote_file.resource_13", "sha1", "16f83ae69627dbbcd1af99cd082631d14df45ab0"),
+					resource.TestCheckResourceAttr(
+						"remote_file.resource_13", "md5", "cdebc5a1a0642a4535c4d692387694f4"),
+					resource.TestCheckResourceAttr(
+						"remote_file.resource_13", "size", "11"),
+					resource.TestCheckResourceAttr(
+						"data.remote_file.resource_13", "sha256", "458d57f1b3576125be2f8322017c416a3865a343e58aa497a70f219bb99f2bb5"),
+				),
+			},
+			{
+				// Modify the remote file, which must be detected by its hash
+				PreConfig: func() {
+					writeFileToHost("remotehost:22", "/tmp/resource_13.txt", "modified", "root", "root")
+				},
+				Config: config,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr(
+						"data.remote_file.resource_13", "content", "resource_13"),
+				),
+			},
+			{
+				Config: `
+				resource "remote_file" "resource_13" {
+					conn {
+						host = "remotehost"
+						user = "root"
+						sudo = true
+						password = "password"
+					}
+					path = "/tmp/resource_13.txt"
+					content = "resource_13"
+					store_content = false
+				}
+				`,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr(
+						"remote_file.resource_13", "sha256", "458d57f1b3576125be2f8322017c416a3865a343e58aa497a70f219bb99f2bb5"),
+				),
+			},
+			{
+				Config: emptyConfig,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr(
+						"remote_file.resource_13", "sha256", "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"),
+				),
+			},
+			{
+				// Empty content is unchanged like any other content
+				Config:   emptyConfig,
+				PlanOnly: true,
+			},
+		},
+	})
+}
+
+func TestAccResourceRemoteFileAtomic(t *testing.T) {
+	resource.UnitTest(t, resource.TestCase{
+		PreCheck:                 func() { testAccPreCheck(t) },
+		ProtoV5ProviderFactories: protoV5ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: `
+				resource "remote_file" "resource_14" {
+					provider = remotehost
+					path = "/tmp/resource_14.txt"
+					content = "resource_14"
+					permissions = "0600"
+					atomic = true
+				}
+
+				resource "remote_file" "resource_15" {
+					conn {
+						host = "remotehost"
+						user = "root"
+						sudo = true
+						password = "password"
+					}
+					path = "/tmp/resource_15.txt"
+					content = "resource_15"
+					owner = "1000"
+					group = "1001"
+					atomic = true
+				}
+				`,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr(
+						"remote_file.resource_14", "content", "resource_14"),
+					resource.TestCheckResourceAttr(
+						"remote_file.resource_14", "permissions", "0600"),
+					resource.TestCheckResourceAttr(
+						"remote_file.resource_15", "content", "resource_15"),
+					resource.TestCheckResourceAttr(
+						"remote_file.resource_15", "owner", "1000"),
+				),
+			},
+		},
+	})
+}
+
+func TestAccResourceRemoteFileAtomicKeepsOwnership(t *testing.T) {
+	resource.UnitTest(t, resource.TestCase{
+		PreCheck:                 func() { testAccPreCheck(t) },
+		ProtoV5ProviderFactories: protoV5ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				// The ownership of the replaced file is kept, although
+				// neither owner nor group are set
+				PreConfig: func() {
+					writeFileToHost("remotehost:22", "/tmp/resource_29.txt", "existing", "1001", "1000")
+				},
+				Config: `
+				resource "remote_file" "resource_29" {
+					conn {
+						host = "remotehost"
+						user = "root"
+						sudo = true
+						password = "password"
+					}
+					path = "/tmp/resource_29.txt"
+					content = "resource_29"
+					atomic = true
+				}
+
+				data "remote_file" "resource_29" {
+					provider = remotehost
+					path = remote_file.resource_29.path
+				}
+				`,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr(
+						"data.remote_file.resource_29", "content", "resource_29"),
+					resource.TestCheckResourceAttr(
+						"data.remote_file.resource_29", "owner", "1000"),
+					resource.TestCheckResourceAttr(
+						"data.remote_file.resource_29", "group", "1001"),
+				),
+			},
+		},
+	})
+}
+
+func TestAccResourceRemoteFileBackup(t *testing.T) {
+	config := func(content string) string {
+		return fmt.Sprintf(`
+		resource "remote_file" "resource_16" {
+			provider = remotehost
+			path = "/tmp/resource_16.txt"
+			content = "%s"
+			backup = true
+			backup_keep = 1
+		}
+		`, content)
+	}
+
+	resource.UnitTest(t, resource.TestCase{
+		PreCheck:                 func() { testAccPreCheck(t) },
+		ProtoV5ProviderFactories: protoV5ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: config("resource_16"),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr(
+						"remote_file.resource_16", "backup_path", ""),
+				),
+			},
+			{
+				Config: config("resource_16_modified"),
+				Check: resource.ComposeTestCheckFunc(
 					resource.TestMatchResourceAttr(
-						"remote_file.resource_6", "group", regexp.MustCompile("")),
+						"remote_file.resource_16", "backup_path", regexp.MustCompile(`^/tmp/resource_16\.txt\.\d{8}T\d{6}\.\d{9}Z\.bak$`)),
 				),
 			},
 		},
 	})
 }
+
+func TestAccResourceRemoteFileBackupKeep(t *testing.T) {
+	config := func(content string) string {
+		return fmt.Sprintf(`
+		resource "remote_file" "resource_28" {
+			provider = remotehost
+			path = "/tmp/resource_28.txt"
+			content = "%s"
+			backup = true
+			backup_keep = 2
+		}
+		`, content)
+	}
+
+	resource.UnitTest(t, resource.TestCase{
+		PreCheck:                 func() { testAccPreCheck(t) },
+		ProtoV5ProviderFactories: protoV5ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: config("v1"),
+			},
+			{
+				Config: config("v2"),
+			},
+			{
+				Config: config("v3"),
+			},
+			{
+				// Only the two newest of the three backups are kept, listed
+				// from oldest to newest
+				Config: config("v4") + `
+				data "remote_command" "resource_28_backups" {
+					provider = remotehost
+					command = "for f in /tmp/resource_28.txt.*.bak; do cat $f; echo; done"
+					depends_on = [remote_file.resource_28]
+				}
+				`,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr(
+						"data.remote_command.resource_28_backups", "stdout", "v2\nv3\n"),
+				),
+			},
+		},
+	})
+}
+
+func TestAccResourceRemoteFileValidateCommand(t *testing.T) {
+	config := func(content string, atomic bool) string {
+		return fmt.Sprintf(`
+		resource "remote_file" "resource_17" {
+			provider = remotehost
+			path = "/tmp/resource_17.txt"
+			content = "%s"
+			atomic = %t
+			validate_command = "grep -q ^valid %%s"
+		}
+		`, content, atomic)
+	}
+
+	resource.UnitTest(t, resource.TestCase{
+		PreCheck:                 func() { testAccPreCheck(t) },
+		ProtoV5ProviderFactories: protoV5ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config:      config("invalid", false),
+				ExpectError: regexp.MustCompile("remote file failed validation"),
+			},
+			{
+				Config: config("valid", false),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr(
+						"remote_file.resource_17", "content", "valid"),
+				),
+			},
+			{
+				Config:      config("invalid", true),
+				ExpectError: regexp.MustCompile("remote file failed validation"),
+			},
+			{
+				Config: config("valid_atomic", true),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr(
+						"remote_file.resource_17", "content", "valid_atomic"),
+				),
+			},
+		},
+	})
+}
+
+func TestAccResourceRemoteFileCommandHooks(t *testing.T) {
+	config := func(content string, permissions string) string {
+		return fmt.Sprintf(`
+		resource "remote_file" "resource_18" {
+			provider = remotehost
+			path = "/tmp/resource_18.txt"
+			content = "%s"
+			permissions = "%s"
+			on_create_command = "echo created > /tmp/resource_18.log"
+			on_update_command = "echo updated >> /tmp/resource_18.log"
+		}
+
+		data "remote_file" "resource_18_log" {
+			provider = remotehost
+			path = "/tmp/resource_18.log"
+			depends_on = [remote_file.resource_18]
+		}
+		`, content, permissions)
+	}
+
+	resource.UnitTest(t, resource.TestCase{
+		PreCheck:                 func() { testAccPreCheck(t) },
+		ProtoV5ProviderFactories: protoV5ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: config("resource_18", "0644"),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr(
+						"data.remote_file.resource_18_log", "content", "created\n"),
+				),
+			},
+			{
+				Config: config("resource_18_modified", "0644"),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr(
+						"data.remote_file.resource_18_log", "content", "created\nupdated\n"),
+				),
+			},
+			{
+				Config: config("resource_18_modified", "0600"),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr(
+						"data.remote_file.resource_18_log", "content", "created\nupdated\nupdated\n"),
+				),
+			},
+		},
+	})
+}
+
+func TestAccResourceRemoteFileHostilePath(t *testing.T) {
+	resource.UnitTest(t, resource.TestCase{
+		PreCheck:                 func() { testAccPreCheck(t) },
+		ProtoV5ProviderFactories: protoV5ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: `
+				resource "remote_file" "resource_19" {
+					conn {
+						host = "remotehost"
+						user = "root"
+						sudo = true
+						password = "password"
+					}
+					path = "/tmp/resource 19 $(touch /tmp/pwned) 'quoted'.txt"
+					content = "resource_19"
+					owner = "1000"
+					atomic = true
+					backup = true
+				}
+
+				data "remote_command" "resource_19_pwned" {
+					provider = remotehost
+					command = "test -e /tmp/pwned"
+					depends_on = [remote_file.resource_19]
+				}
+				`,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr(
+						"remote_file.resource_19", "content", "resource_19"),
+					resource.TestCheckResourceAttr(
+						"remote_file.resource_19", "owner", "1000"),
+					resource.TestCheckResourceAttr(
+						"data.remote_command.resource_19_pwned", "exit_code", "1"),
+				),
+			},
+		},
+	})
+}
+
+func TestAccResourceRemoteFileBecome(t *testing.T) {
+	resource.UnitTest(t, resource.TestCase{
+		PreCheck:                 func() { testAccPreCheck(t) },
+		ProtoV5ProviderFactories: protoV5ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: `
+				resource "remote_file" "resource_20" {
+					conn {
+						host = "remotehost"
+						user = "bob"
+						password = "pwd"
+						become {
+							password = "pwd"
+						}
+					}
+					path = "/root/resource_20.txt"
+					content = "resource_20"
+					permissions = "0600"
+				}
+
+				resource "remote_file" "resource_21" {
+					conn {
+						host = "remotehost"
+						user = "bob"
+						password = "pwd"
+						become {
+							method = "doas"
+						}
+					}
+					path = "/root/resource_21.txt"
+					content = "resource_21"
+				}
+
+				resource "remote_file" "resource_22" {
+					conn {
+						host = "remotehost"
+						user = "root"
+						password = "password"
+						become {
+							user = "bob"
+						}
+					}
+					path = "/home/bob/resource_22.txt"
+					content = "resource_22"
+					owner_name = "bob"
+				}
+				`,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr(
+						"remote_file.resource_20", "content", "resource_20"),
+					resource.TestCheckResourceAttr(
+						"remote_file.resource_20", "permissions", "0600"),
+					resource.TestCheckResourceAttr(
+						"remote_file.resource_21", "content", "resource_21"),
+					resource.TestCheckResourceAttr(
+						"remote_file.resource_22", "owner_name", "bob"),
+				),
+			},
+		},
+	})
+}
+
+func TestAccResourceRemoteFileSudoSFTP(t *testing.T) {
+	resource.UnitTest(t, resource.TestCase{
+		PreCheck:                 func() { testAccPreCheck(t) },
+		ProtoV5ProviderFactories: protoV5ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: `
+				resource "remote_file" "resource_23" {
+					conn {
+						host = "remotehost"
+						user = "bob"
+						password = "pwd"
+						sftp_server_path = "/usr/lib/ssh/sftp-server"
+						become {
+							password = "pwd"
+						}
+					}
+					path = "/root/resource_23.txt"
+					content = "resource_23"
+					permissions = "0600"
+					atomic = true
+					backup = true
+				}
+
+				resource "remote_directory" "resource_24" {
+					conn {
+						host = "remotehost"
+						user = "bob"
+						password = "pwd"
+						sftp_server_path = "/usr/lib/ssh/sftp-server"
+						become {
+							method = "doas"
+						}
+					}
+					path = "/root/resource_24"
+				}
+				`,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr(
+						"remote_file.resource_23", "content", "resource_23"),
+					resource.TestCheckResourceAttr(
+						"remote_file.resource_23", "permissions", "0600"),
+					resource.TestCheckResourceAttr(
+						"remote_directory.resource_24", "permissions", "0755"),
+				),
+			},
+		},
+	})
+}
+
+func TestAccResourceRemoteFileSSHConfig(t *testing.T) {
+	sshConfig := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(sshConfig, []byte(`
+Host server
+    HostName remotehost
+    User root
+    ProxyJump root@remotehost2
+`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	resource.UnitTest(t, resource.TestCase{
+		PreCheck:                 func() { testAccPreCheck(t) },
+		ProtoV5ProviderFactories: protoV5ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: fmt.Sprintf(`
+				resource "remote_file" "resource_25" {
+					conn {
+						host = "server"
+						password = "password"
+						ssh_config = %q
+					}
+					path = "/tmp/resource_25.txt"
+					content = "resource_25"
+				}
+				`, sshConfig),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr(
+						"remote_file.resource_25", "content", "resource_25"),
+				),
+			},
+		},
+	})
+}
+
+func TestAccResourceRemoteFileImport(t *testing.T) {
+	config := `
+	resource "remote_file" "resource_26" {
+		provider = remotehost
+
+		path = "/tmp/resource_26.txt"
+		content = "resource_26"
+		permissions = "0640"
+		owner_name = "root"
+		group_name = "root"
+	}
+
+	resource "remote_file" "resource_27" {
+		provider = remotehost
+
+		path = "/tmp/resource_27.bin"
+		content_base64 = "/w=="
+		owner_name = "root"
+		group_name = "root"
+	}
+	`
+
+	resource.UnitTest(t, resource.TestCase{
+		PreCheck:                 func() { testAccPreCheck(t) },
+		ProtoV5ProviderFactories: protoV5ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: config,
+			},
+			{
+				ResourceName:      "remote_file.resource_26",
+				ImportState:       true,
+				ImportStateId:     "root@remotehost:22:/tmp/resource_26.txt",
+				ImportStateVerify: true,
+			},
+			{
+				ResourceName:      "remote_file.resource_27",
+				ImportState:       true,
+				ImportStateId:     "remotehost:22:/tmp/resource_27.bin",
+				ImportStateVerify: true,
+			},
+			{
+				ResourceName:       "remote_file.resource_26",
+				ImportState:        true,
+				ImportStateId:      "remotehost:22:/tmp/resource_26.txt",
+				ImportStatePersist: true,
+			},
+			{
+				// The imported state plans no changes.
+				Config:   config,
+				PlanOnly: true,
+			},
+			{
+				ResourceName:  "remote_file.resource_26",
+				ImportState:   true,
+				ImportStateId: "remotehost2:22:/tmp/resource_26.txt",
+				ExpectError:   regexp.MustCompile("conn of the provider connects to root@remotehost:22"),
+			},
+		},
+	})
+}
+
+func TestResourceRemoteFileUpgradeState(t *testing.T) {
+	// State written by the SDK, which stores strings and numbers that are
+	// not set as their zero value.
+	sdkState := `{
+		"id": "remotehost:22:/tmp/upgrade.txt",
+		"conn": [{
+			"host": "remotehost", "port": 22, "timeout": 0, "user": "root",
+			"agent": false, "agent_socket": "", "agent_identity": "",
+			"password": "password", "keyboard_interactive_answers": {},
+			"private_key": "", "private_key_pass": "", "private_key_path": "",
+			"private_key_env_var": "", "certificate": "", "certificate_path": "",
+			"host_ca_public_key": "", "host_key": "", "known_hosts_path": "",
+			"host_key_algorithms": [], "strict_host_key_checking": "",
+			"sudo": false, "sftp_server_path": "", "ssh_config": "",
+			"become": [], "retry": [], "proxy_jump": []
+		}],
+		"path": "/tmp/upgrade.txt",
+		"content": "",
+		"content_base64": "dXBncmFkZQ==",
+		"source": "",
+		"permissions": "0644",
+		"group": "", "group_name": "", "owner": "", "owner_name": "root",
+		"atomic": false,
+		"validate_command": "",
+		"on_create_command": "", "on_update_command": "", "on_destroy_command": "",
+		"backup": false, "backup_suffix": ".bak", "backup_keep": 0, "backup_path": "",
+		"store_content": true,
+		"sha256": "", "sha1": "", "md5": "", "size": 7,
+		"host_key": ""
+	}`
+
+	server, err := newTestServer(New("dev")())
+	if err != nil {
+		t.Fatalf("unable to create mux server: %s", err.Error())
+	}
+	ctx := context.Background()
+
+	schemas, err := server.GetProviderSchema(ctx, &tfprotov5.GetProviderSchemaRequest{})
+	if err != nil {
+		t.Fatalf("unable to get provider schema: %s", err.Error())
+	}
+	schema := schemas.ResourceSchemas["remote_file"]
+
+	resp, err := server.UpgradeResourceState(ctx, &tfprotov5.UpgradeResourceStateRequest{
+		TypeName: "remote_file",
+		Version:  0,
+		RawState: &tfprotov5.RawState{JSON: []byte(sdkState)},
+	})
+	if err != nil {
+		t.Fatalf("unable to upgrade state: %s", err.Error())
+	}
+	for _, d := range resp.Diagnostics {
+		t.Fatalf("%s: %s", d.Summary, d.Detail)
+	}
+
+	value, err := resp.UpgradedState.Unmarshal(schema.ValueType())
+	if err != nil {
+		t.Fatalf("unable to unmarshal upgraded state: %s", err.Error())
+	}
+	var state map[string]tftypes.Value
+	if err := value.As(&state); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"content", "source", "group", "group_name", "owner", "validate_command", "on_create_command"} {
+		if !state[key].IsNull() {
+			t.Errorf("expected %s to be null, got %s", key, state[key])
+		}
+	}
+	for _, key := range []string{"content_base64", "owner_name", "permissions", "backup_path"} {
+		if state[key].IsNull() {
+			t.Errorf("expected %s to be kept, got null", key)
+		}
+	}
+
+	var conns []tftypes.Value
+	if err := state["conn"].As(&conns); err != nil {
+		t.Fatal(err)
+	}
+	var conn map[string]tftypes.Value
+	if err := conns[0].As(&conn); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"timeout", "agent_socket", "private_key", "keyboard_interactive_answers", "host_key_algorithms", "strict_host_key_checking", "ssh_config"} {
+		if !conn[key].IsNull() {
+			t.Errorf("expected conn.%s to be null, got %s", key, conn[key])
+		}
+	}
+	for _, key := range []string{"host", "port", "user", "password", "agent", "sudo"} {
+		if conn[key].IsNull() {
+			t.Errorf("expected conn.%s to be kept, got null", key)
+		}
+	}
+}
+
+func TestContentChanged(t *testing.T) {
+	// SHA-256 of "resource_13", which is "cmVzb3VyY2VfMTM=" in base64.
+	sha := "458d57f1b3576125be2f8322017c416a3865a343e58aa497a70f219bb99f2bb5"
+	emptySHA := "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+	tests := []struct {
+		storeContent  bool
+		content       types.String
+		contentBase64 types.String
+		stateContent  types.String
+		stateSHA256   string
+		want          bool
+	}{
+		{storeContent: true, content: types.StringValue("resource_13"), stateContent: types.StringValue("resource_13"), stateSHA256: sha, want: false},
+		{storeContent: true, content: types.StringValue("modified"), stateContent: types.StringValue("resource_13"), stateSHA256: sha, want: true},
+		// The remote content is only hashed when store_content is false.
+		{storeContent: false, content: types.StringValue("resource_13"), stateContent: types.StringValue("resource_13"), stateSHA256: sha, want: false},
+		{storeContent: false, content: types.StringValue("resource_13"), stateContent: types.StringValue("resource_13"), stateSHA256: emptySHA, want: true},
+		{storeContent: false, content: types.StringValue(""), stateContent: types.StringValue(""), stateSHA256: emptySHA, want: false},
+		// The SDK did not store the content when store_content was false.
+		{storeContent: false, content: types.StringValue("resource_13"), stateContent: types.StringNull(), stateSHA256: sha, want: false},
+		{storeContent: false, content: types.StringValue(""), stateContent: types.StringNull(), stateSHA256: emptySHA, want: false},
+		{storeContent: false, content: types.StringValue("modified"), stateContent: types.StringNull(), stateSHA256: sha, want: true},
+		{storeContent: false, contentBase64: types.StringValue("cmVzb3VyY2VfMTM="), stateContent: types.StringNull(), stateSHA256: sha, want: false},
+		{storeContent: false, contentBase64: types.StringValue("bW9kaWZpZWQ="), stateContent: types.StringNull(), stateSHA256: sha, want: true},
+		{storeContent: false, content: types.StringUnknown(), stateContent: types.StringNull(), stateSHA256: sha, want: true},
+	}
+
+	for i, test := range tests {
+		plan := remoteFileResourceModel{
+			Content:       test.content,
+			ContentBase64: test.contentBase64,
+			StoreContent:  types.BoolValue(test.storeContent),
+		}
+		state := remoteFileResourceModel{
+			Content:       test.stateContent,
+			ContentBase64: types.StringNull(),
+			SHA256:        types.StringValue(test.stateSHA256),
+		}
+		if got := contentChanged(&plan, &state); got != test.want {
+			t.Errorf("test %d: content changed: %v, want %v", i, got, test.want)
+		}
+	}
+}
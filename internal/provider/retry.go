@@ -0,0 +1,100 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// retryPolicy describes how operations failing with transient errors are
+// retried. A nil policy tries operations once.
+type retryPolicy struct {
+	maxAttempts int
+	// Backoff before the second attempt, which is doubled after every
+	// attempt up to maxBackoff.
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	// Total time after which no more attempts are made. Zero means no
+	// timeout.
+	timeout time.Duration
+}
+
+// do calls f until it succeeds or fails with an error that is not retryable,
+// as long as attempts and time are left. All errors are retryable when
+// retryable is nil.
+func (p *retryPolicy) do(ctx context.Context, retryable func(error) bool, f func() error) error {
+	if p == nil {
+		return f()
+	}
+
+	var deadline time.Time
+	if p.timeout > 0 {
+		deadline = time.Now().Add(p.timeout)
+	}
+
+	backoff := p.initialBackoff
+	for attempt := 1; ; attempt++ {
+		err := f()
+		if err == nil {
+			return nil
+		}
+		if attempt >= p.maxAttempts || (retryable != nil && !retryable(err)) {
+			return retryError(err, attempt)
+		}
+		if !deadline.IsZero() && time.Now().Add(backoff).After(deadline) {
+			return retryError(err, attempt)
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return errors.Join(retryError(err, attempt), ctx.Err())
+		case <-timer.C:
+		}
+
+		backoff = min(2*backoff, p.maxBackoff)
+	}
+}
+
+func retryError(err error, attempts int) error {
+	if attempts == 1 {
+		return err
+	}
+	return fmt.Errorf("%w (gave up after %d attempts)", err, attempts)
+}
+
+// isSessionRefused returns whether err is caused by the remote host refusing
+// to open a session, such as when MaxSessions of the SSH server is exceeded.
+func isSessionRefused(err error) bool {
+	var openChannelErr *ssh.OpenChannelError
+	if !errors.As(err, &openChannelErr) {
+		return false
+	}
+	return openChannelErr.Reason == ssh.Prohibited || openChannelErr.Reason == ssh.ResourceShortage
+}
+
+// isDialError returns whether err is caused by failing to reach the remote
+// host, or by the connection being dropped during the handshake, rather than
+// by the remote host rejecting it, such as when authentication fails or the
+// host key does not match.
+func isDialError(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	// Connecting through a jump host fails when the jump host cannot reach
+	// the remote host.
+	var openChannelErr *ssh.OpenChannelError
+	if errors.As(err, &openChannelErr) && openChannelErr.Reason == ssh.ConnectionFailed {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
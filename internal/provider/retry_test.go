@@ -0,0 +1,80 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	refused := &ssh.OpenChannelError{Reason: ssh.Prohibited, Message: "open failed"}
+	policy := &retryPolicy{maxAttempts: 3, initialBackoff: time.Millisecond, maxBackoff: time.Millisecond}
+
+	tests := []struct {
+		policy       *retryPolicy
+		errs         []error
+		wantAttempts int
+		wantErr      bool
+	}{
+		{policy: nil, errs: []error{refused, nil}, wantAttempts: 1, wantErr: true},
+		{policy: policy, errs: []error{refused, refused, nil}, wantAttempts: 3, wantErr: false},
+		{policy: policy, errs: []error{refused, refused, refused, nil}, wantAttempts: 3, wantErr: true},
+		{policy: policy, errs: []error{errors.New("permission denied"), nil}, wantAttempts: 1, wantErr: true},
+		{policy: policy, errs: []error{fmt.Errorf("wrapped: %w", refused), nil}, wantAttempts: 2, wantErr: false},
+	}
+
+	for i, test := range tests {
+		attempts := 0
+		err := test.policy.do(context.Background(), isSessionRefused, func() error {
+			attempts++
+			return test.errs[attempts-1]
+		})
+		if attempts != test.wantAttempts {
+			t.Errorf("test %d made %d attempts, want %d", i, attempts, test.wantAttempts)
+		}
+		if (err != nil) != test.wantErr {
+			t.Errorf("test %d returned %v", i, err)
+		}
+	}
+}
+
+func TestRetryPolicyTimeout(t *testing.T) {
+	policy := &retryPolicy{maxAttempts: 100, initialBackoff: 20 * time.Millisecond, maxBackoff: time.Second, timeout: 50 * time.Millisecond}
+
+	attempts := 0
+	err := policy.do(context.Background(), nil, func() error {
+		attempts++
+		return errors.New("connection refused")
+	})
+	if err == nil || attempts != 2 {
+		t.Errorf("made %d attempts and returned %v, want 2 attempts and an error", attempts, err)
+	}
+}
+
+func TestIsDialError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{err: &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}, want: true},
+		{err: fmt.Errorf("wrapped: %w", syscall.ECONNRESET), want: true},
+		{err: fmt.Errorf("ssh: handshake failed: %w", io.EOF), want: true},
+		{err: &ssh.OpenChannelError{Reason: ssh.ConnectionFailed, Message: "connect failed"}, want: true},
+		{err: errors.New("ssh: handshake failed: ssh: unable to authenticate"), want: false},
+		{err: fmt.Errorf("ssh: handshake failed: %w", errors.New("knownhosts: key mismatch")), want: false},
+		{err: &ssh.OpenChannelError{Reason: ssh.Prohibited, Message: "administratively prohibited"}, want: false},
+	}
+
+	for i, test := range tests {
+		if got := isDialError(test.err); got != test.want {
+			t.Errorf("test %d: isDialError(%v) = %v, want %v", i, test.err, got, test.want)
+		}
+	}
+}
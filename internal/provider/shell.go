@@ -0,0 +1,136 @@
+package provider
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// unquotedPattern matches words that are passed literally by POSIX shells,
+// which are left unquoted to keep commands readable in errors.
+var unquotedPattern = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+
+// quote quotes s for POSIX shells, such that it is passed as a single literal
+// word.
+func quote(s string) string {
+	if unquotedPattern.MatchString(s) {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'"'"'`) + "'"
+}
+
+// shellCommand builds a command line for POSIX shells, where every word is
+// quoted.
+type shellCommand struct {
+	words  []string
+	become *become
+}
+
+// newShellCommand returns a command running name with the options. Paths and
+// other operands are added with operands, separated from the options by `--`.
+func newShellCommand(name string, options ...string) *shellCommand {
+	return &shellCommand{words: append([]string{name}, options...)}
+}
+
+// shellScript returns a command running script in a shell, where args are
+// available as `$1`, `$2` and so on.
+func shellScript(script string, args ...string) *shellCommand {
+	return newShellCommand("sh", "-c").operands(append([]string{script, "sh"}, args...)...)
+}
+
+// arguments adds words that are not separated from the options, for commands
+// such as `test` which do not support `--`.
+func (c *shellCommand) arguments(arguments ...string) *shellCommand {
+	c.words = append(c.words, arguments...)
+	return c
+}
+
+// operands adds words after `--`, such that they are never interpreted as
+// options even when they start with `-`.
+func (c *shellCommand) operands(operands ...string) *shellCommand {
+	c.words = append(c.words, "--")
+	c.words = append(c.words, operands...)
+	return c
+}
+
+// withBecome runs the command as another user, unless become is nil.
+func (c *shellCommand) withBecome(become *become) *shellCommand {
+	c.become = become
+	return c
+}
+
+// stdin returns the stdin of the command, prefixed with the password used to
+// become another user when needed.
+func (c *shellCommand) stdin(stdin io.Reader) io.Reader {
+	if c.become == nil || c.become.method != "sudo" || c.become.password == "" {
+		return stdin
+	}
+
+	password := strings.NewReader(c.become.password + "\n")
+	if stdin == nil {
+		return password
+	}
+	return io.MultiReader(password, stdin)
+}
+
+func (c *shellCommand) String() string {
+	cmd := quoteAll(c.words)
+	if c.become == nil {
+		return cmd
+	}
+	return c.become.wrap(cmd)
+}
+
+// become describes how to run commands as another user.
+type become struct {
+	// One of sudo, doas, su or pbrun.
+	method string
+	// The user to become, which defaults to root when empty.
+	user string
+	// The password of the connecting user, only supported by sudo.
+	password string
+	flags    []string
+}
+
+// validate returns an error unless method supports the settings.
+func (b *become) validate() error {
+	if b.password != "" && b.method != "sudo" {
+		return fmt.Errorf("become password is only supported by sudo, not %s", b.method)
+	}
+	return nil
+}
+
+// wrap returns a command running cmd as the user to become.
+func (b *become) wrap(cmd string) string {
+	words := []string{b.method}
+	switch b.method {
+	case "su":
+		words = append(words, b.flags...)
+		words = append(words, "-c", cmd)
+		if b.user != "" {
+			words = append(words, b.user)
+		}
+		return quoteAll(words)
+	case "sudo":
+		if b.password != "" {
+			// Ignore cached credentials, such that the password is always
+			// read from stdin before the stdin of the command.
+			words = append(words, "-S", "-k", "-p", "")
+		}
+	}
+
+	if b.user != "" {
+		words = append(words, "-u", b.user)
+	}
+	words = append(words, b.flags...)
+	return quoteAll(words) + " " + cmd
+}
+
+func quoteAll(words []string) string {
+	quoted := make([]string, 0, len(words))
+	for _, word := range words {
+		quoted = append(quoted, quote(word))
+	}
+	return strings.Join(quoted, " ")
+}
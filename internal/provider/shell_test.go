@@ -0,0 +1,167 @@
+package provider
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var hostilePaths = []string{
+	"/tmp/plain.txt",
+	"/tmp/with space.txt",
+	"/tmp/it's.txt",
+	`/tmp/double"quote.txt`,
+	"/tmp/$(touch pwned).txt",
+	"/tmp/`touch pwned`.txt",
+	"/tmp/$HOME.txt",
+	"/tmp/semi;colon.txt",
+	"/tmp/pipe|amp&.txt",
+	"/tmp/glob*?[a].txt",
+	"/tmp/back\\slash.txt",
+	"/tmp/new\nline.txt",
+	"/tmp/tab\t.txt",
+	"-rf",
+	"--",
+	"",
+	"'",
+	"''",
+	"~root",
+}
+
+func TestQuote(t *testing.T) {
+	for _, path := range hostilePaths {
+		output, err := exec.Command("sh", "-c", "printf '%s' "+quote(path)).Output()
+		if err != nil {
+			t.Fatalf("quote(%q) = %s: %s", path, quote(path), err)
+		}
+		if string(output) != path {
+			t.Errorf("quote(%q) = %s, which the shell reads as %q", path, quote(path), output)
+		}
+	}
+}
+
+func TestShellCommand(t *testing.T) {
+	tests := []struct {
+		command *shellCommand
+		want    string
+	}{
+		{
+			command: newShellCommand("chmod").operands("0644", "/tmp/file.txt"),
+			want:    "chmod -- 0644 /tmp/file.txt",
+		},
+		{
+			command: newShellCommand("chown").operands("1000", "/tmp/it's $(id).txt").withBecome(&become{method: "sudo"}),
+			want:    `sudo chown -- 1000 '/tmp/it'"'"'s $(id).txt'`,
+		},
+		{
+			command: newShellCommand("stat", "-c", "%a").operands("-rf"),
+			want:    "stat -c %a -- -rf",
+		},
+		{
+			command: newShellCommand("test", "!", "-f").arguments("/tmp/a b"),
+			want:    "test '!' -f '/tmp/a b'",
+		},
+		{
+			command: newShellCommand("cat").operands("/etc/shadow").withBecome(&become{method: "doas", user: "bob"}),
+			want:    "doas -u bob cat -- /etc/shadow",
+		},
+		{
+			command: newShellCommand("cat").operands("/etc/shadow").withBecome(&become{method: "sudo", password: "secret", flags: []string{"-H"}}),
+			want:    "sudo -S -k -p '' -H cat -- /etc/shadow",
+		},
+		{
+			command: newShellCommand("cat").operands("/tmp/a b").withBecome(&become{method: "su", user: "bob", flags: []string{"-l"}}),
+			want:    `su -l -c 'cat -- '"'"'/tmp/a b'"'"'' bob`,
+		},
+		{
+			command: shellScript(`cat > "$1"`, "/tmp/a b").withBecome(&become{method: "pbrun"}),
+			want:    `pbrun sh -c -- 'cat > "$1"' sh '/tmp/a b'`,
+		},
+		{
+			command: shellScript("echo 'hello' && exit 1").withBecome(&become{method: "sudo"}),
+			want:    `sudo sh -c -- 'echo '"'"'hello'"'"' && exit 1' sh`,
+		},
+	}
+
+	for _, test := range tests {
+		if got := test.command.String(); got != test.want {
+			t.Errorf("got %s, want %s", got, test.want)
+		}
+	}
+}
+
+func TestShellCommandStdin(t *testing.T) {
+	tests := []struct {
+		become *become
+		want   string
+	}{
+		{become: nil, want: "content"},
+		{become: &become{method: "sudo"}, want: "content"},
+		{become: &become{method: "sudo", password: "secret"}, want: "secret\ncontent"},
+		{become: &become{method: "doas", password: "secret"}, want: "content"},
+	}
+
+	for _, test := range tests {
+		cmd := newShellCommand("tee").withBecome(test.become)
+		stdin, err := io.ReadAll(cmd.stdin(strings.NewReader("content")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(stdin) != test.want {
+			t.Errorf("%s got stdin %q, want %q", cmd, stdin, test.want)
+		}
+	}
+}
+
+func TestShellCommandHostileFileNames(t *testing.T) {
+	dir := t.TempDir()
+	names := []string{
+		"with space",
+		"it's",
+		"$(touch pwned)",
+		"`touch pwned`",
+		"semi;touch pwned",
+		"glob*",
+		"new\nline",
+		"-rf",
+		"--",
+	}
+
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		cmd := strings.Join([]string{
+			newShellCommand("test", "-f").arguments(path).String(),
+			newShellCommand("chmod").operands("0600", path).String(),
+			newShellCommand("cat").operands(path).String(),
+		}, " && ")
+		output, err := exec.Command("sh", "-c", cmd).Output()
+		if err != nil {
+			t.Fatalf("%s: %s", cmd, err)
+		}
+		if string(output) != name {
+			t.Errorf("%s printed %q, want %q", cmd, output, name)
+		}
+
+		stat, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stat.Mode().Perm() != 0600 {
+			t.Errorf("%s did not change permissions of %q", cmd, path)
+		}
+	}
+
+	if _, err := os.Stat("pwned"); err == nil {
+		t.Errorf("file names were executed as commands")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "pwned")); err == nil {
+		t.Errorf("file names were executed as commands")
+	}
+}
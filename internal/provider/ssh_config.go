@@ -0,0 +1,310 @@
+package provider
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// sshConfig is an OpenSSH client configuration file, such as ~/.ssh/config.
+// Only the options used to connect to hosts are supported, and Match blocks
+// and Include directives are ignored.
+type sshConfig struct {
+	blocks []sshConfigBlock
+}
+
+// sshConfigBlock holds the options of a Host block, or the options before the
+// first Host block which apply to all hosts.
+type sshConfigBlock struct {
+	patterns []string
+	match    bool
+	options  []sshConfigOption
+}
+
+type sshConfigOption struct {
+	// Lowercase, as keywords are case-insensitive.
+	keyword string
+	args    []string
+}
+
+// sshHostConfig holds the options that apply to a host in an ssh config file.
+type sshHostConfig struct {
+	// Defaults to the host looked up when not set.
+	hostName string
+	user     string
+	// Zero when not set.
+	port           int
+	identityFiles  []string
+	identitiesOnly bool
+	// Jump hosts in `[user@]host[:port]` format.
+	proxyJump []string
+}
+
+func readSSHConfig(path string) (*sshConfig, error) {
+	path, err := expandHome(path)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read ssh config: %s", err.Error())
+	}
+	defer file.Close()
+
+	config, err := parseSSHConfig(file)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse ssh config %s: %s", path, err.Error())
+	}
+	return config, nil
+}
+
+func parseSSHConfig(r io.Reader) (*sshConfig, error) {
+	config := &sshConfig{blocks: []sshConfigBlock{{patterns: []string{"*"}}}}
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		words, err := splitSSHConfigLine(scanner.Text())
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", line, err.Error())
+		}
+		if len(words) == 0 {
+			continue
+		}
+
+		keyword := strings.ToLower(words[0])
+		args := words[1:]
+		if len(args) == 0 {
+			return nil, fmt.Errorf("line %d: missing argument for %s", line, words[0])
+		}
+
+		switch keyword {
+		case "host":
+			config.blocks = append(config.blocks, sshConfigBlock{patterns: args})
+		case "match":
+			config.blocks = append(config.blocks, sshConfigBlock{match: true})
+		default:
+			block := &config.blocks[len(config.blocks)-1]
+			block.options = append(block.options, sshConfigOption{keyword: keyword, args: args})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// splitSSHConfigLine splits a line into its keyword and arguments, which are
+// separated by whitespace or a single `=` after the keyword, and may be
+// quoted with double quotes.
+func splitSSHConfigLine(line string) ([]string, error) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil, nil
+	}
+
+	i := strings.IndexAny(line, " \t=")
+	if i < 0 {
+		return []string{line}, nil
+	}
+	keyword := line[:i]
+	rest := strings.TrimPrefix(strings.TrimLeft(line[i:], " \t"), "=")
+
+	words := []string{keyword}
+	var word strings.Builder
+	inWord, quoted := false, false
+	for _, r := range rest {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			inWord = true
+		case !quoted && (r == ' ' || r == '\t'):
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quoted {
+		return nil, fmt.Errorf("unterminated quote")
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+
+	return words, nil
+}
+
+// lookup returns the options that apply to host. Like ssh, the first value
+// obtained for each option is used, except for identity files which are all
+// used.
+func (c *sshConfig) lookup(host string) (*sshHostConfig, error) {
+	hostConfig := &sshHostConfig{}
+	seen := map[string]bool{}
+
+	for _, block := range c.blocks {
+		if block.match || !matchHostPatterns(block.patterns, host) {
+			continue
+		}
+
+		for _, option := range block.options {
+			if option.keyword == "identityfile" {
+				hostConfig.identityFiles = append(hostConfig.identityFiles, option.args[0])
+				continue
+			}
+			if seen[option.keyword] {
+				continue
+			}
+			seen[option.keyword] = true
+
+			switch option.keyword {
+			case "hostname":
+				hostConfig.hostName = option.args[0]
+			case "user":
+				hostConfig.user = option.args[0]
+			case "port":
+				port, err := strconv.Atoi(option.args[0])
+				if err != nil {
+					return nil, fmt.Errorf("invalid port %q for host %s in ssh config", option.args[0], host)
+				}
+				hostConfig.port = port
+			case "identitiesonly":
+				hostConfig.identitiesOnly = strings.EqualFold(option.args[0], "yes")
+			case "proxyjump":
+				if !strings.EqualFold(option.args[0], "none") {
+					hostConfig.proxyJump = strings.Split(option.args[0], ",")
+				}
+			}
+		}
+	}
+
+	if hostConfig.hostName == "" {
+		hostConfig.hostName = host
+	}
+	hostConfig.hostName = strings.ReplaceAll(hostConfig.hostName, "%h", host)
+
+	for i, identityFile := range hostConfig.identityFiles {
+		identityFile, err := hostConfig.expandTokens(identityFile)
+		if err != nil {
+			return nil, err
+		}
+		hostConfig.identityFiles[i] = identityFile
+	}
+
+	return hostConfig, nil
+}
+
+// expandTokens expands `~` and the tokens supported in IdentityFile.
+func (c *sshHostConfig) expandTokens(s string) (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	localUser := ""
+	if u, err := user.Current(); err == nil {
+		localUser = u.Username
+	}
+
+	port := c.port
+	if port == 0 {
+		port = 22
+	}
+
+	s = strings.NewReplacer(
+		"%%", "%",
+		"%d", home,
+		"%h", c.hostName,
+		"%p", strconv.Itoa(port),
+		"%r", c.user,
+		"%u", localUser,
+	).Replace(s)
+	return expandHome(s)
+}
+
+// matchHostPatterns returns whether host matches any of the patterns, and
+// none of the negated patterns.
+func matchHostPatterns(patterns []string, host string) bool {
+	matched := false
+	for _, pattern := range patterns {
+		if negated, ok := strings.CutPrefix(pattern, "!"); ok {
+			if matchHostPattern(negated, host) {
+				return false
+			}
+			continue
+		}
+		if matchHostPattern(pattern, host) {
+			matched = true
+		}
+	}
+	return matched
+}
+
+// matchHostPattern matches host against a pattern where `*` matches any
+// characters and `?` matches a single character.
+func matchHostPattern(pattern string, host string) bool {
+	for pattern != "" {
+		switch pattern[0] {
+		case '*':
+			for i := len(host); i >= 0; i-- {
+				if matchHostPattern(pattern[1:], host[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if host == "" {
+				return false
+			}
+		default:
+			if host == "" || !strings.EqualFold(pattern[:1], host[:1]) {
+				return false
+			}
+		}
+		pattern, host = pattern[1:], host[1:]
+	}
+	return host == ""
+}
+
+// parseJumpSpec parses a jump host in `[user@]host[:port]` format, where port
+// is zero when not set.
+func parseJumpSpec(spec string) (string, string, int, error) {
+	jumpUser, host, ok := strings.Cut(spec, "@")
+	if !ok {
+		jumpUser, host = "", spec
+	}
+
+	port := 0
+	if i := strings.LastIndex(host, ":"); i >= 0 && !strings.HasSuffix(host, "]") {
+		p, err := strconv.Atoi(host[i+1:])
+		if err != nil {
+			return "", "", 0, fmt.Errorf("invalid jump host %q in ssh config", spec)
+		}
+		host, port = host[:i], p
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+
+	return jumpUser, host, port, nil
+}
+
+func expandHome(path string) (string, error) {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path, nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, path[1:]), nil
+}
@@ -0,0 +1,136 @@
+package provider
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testSSHConfig = `
+# Defaults for all hosts are only used when not set below.
+IdentityFile ~/.ssh/id_ed25519
+
+Host db1 db2
+    HostName %h.internal.example.com
+    User postgres
+    Port=2222
+    ProxyJump john@bastion:2200,gateway
+
+Host bastion
+    HostName bastion.example.com
+    IdentityFile "/keys/bastion key"
+    IdentitiesOnly yes
+
+Host *.example.com !excluded.example.com
+    User admin
+
+Match host db1
+    User ignored
+
+Host *
+    User default
+    Port 22
+    ProxyJump none
+`
+
+func TestSSHConfigLookup(t *testing.T) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := parseSSHConfig(strings.NewReader(testSSHConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		host string
+		want sshHostConfig
+	}{
+		{
+			host: "db1",
+			want: sshHostConfig{
+				hostName:      "db1.internal.example.com",
+				user:          "postgres",
+				port:          2222,
+				identityFiles: []string{filepath.Join(home, ".ssh/id_ed25519")},
+				proxyJump:     []string{"john@bastion:2200", "gateway"},
+			},
+		},
+		{
+			host: "bastion",
+			want: sshHostConfig{
+				hostName:       "bastion.example.com",
+				user:           "default",
+				port:           22,
+				identityFiles:  []string{filepath.Join(home, ".ssh/id_ed25519"), "/keys/bastion key"},
+				identitiesOnly: true,
+			},
+		},
+		{
+			host: "web.example.com",
+			want: sshHostConfig{
+				hostName:      "web.example.com",
+				user:          "admin",
+				port:          22,
+				identityFiles: []string{filepath.Join(home, ".ssh/id_ed25519")},
+			},
+		},
+		{
+			host: "excluded.example.com",
+			want: sshHostConfig{
+				hostName:      "excluded.example.com",
+				user:          "default",
+				port:          22,
+				identityFiles: []string{filepath.Join(home, ".ssh/id_ed25519")},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		got, err := config.lookup(test.host)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(*got, test.want) {
+			t.Errorf("lookup(%s) = %+v, want %+v", test.host, *got, test.want)
+		}
+	}
+}
+
+func TestSSHConfigParseErrors(t *testing.T) {
+	for _, config := range []string{
+		"Host",
+		`IdentityFile "/unterminated`,
+	} {
+		if _, err := parseSSHConfig(strings.NewReader(config)); err == nil {
+			t.Errorf("expected error parsing %q", config)
+		}
+	}
+}
+
+func TestParseJumpSpec(t *testing.T) {
+	tests := []struct {
+		spec string
+		user string
+		host string
+		port int
+	}{
+		{spec: "bastion", host: "bastion"},
+		{spec: "john@bastion", user: "john", host: "bastion"},
+		{spec: "john@bastion:2200", user: "john", host: "bastion", port: 2200},
+		{spec: "[2001:db8::1]:2200", host: "2001:db8::1", port: 2200},
+	}
+
+	for _, test := range tests {
+		user, host, port, err := parseJumpSpec(test.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user != test.user || host != test.host || port != test.port {
+			t.Errorf("parseJumpSpec(%s) = %s, %s, %d", test.spec, user, host, port)
+		}
+	}
+}
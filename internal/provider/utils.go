@@ -1,9 +1,12 @@
 package provider
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"strings"
 
+	"github.com/hashicorp/terraform-plugin-framework/types"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 	"golang.org/x/crypto/ssh"
 )
@@ -37,13 +40,77 @@ func GetOk[T any](d *schema.ResourceData, key string) (T, bool, error) {
 	return t, true, fmt.Errorf("%w: %s to %T: %v", errTypecast, key, t, raw)
 }
 
-func parsePrivateKey(d *schema.ResourceData, privateKey string) (ssh.Signer, error) {
-	privateKeyPass, ok, err := GetOk[string](d, "conn.0.private_key_pass")
-	if ok {
-		if err != nil {
-			return nil, err
-		}
-		return ssh.ParsePrivateKeyWithPassphrase([]byte(privateKey), []byte(privateKeyPass))
+// stringOrDefault returns the value of value, or defaultValue when value is
+// null or unknown.
+func stringOrDefault(value types.String, defaultValue string) string {
+	if value.IsNull() || value.IsUnknown() {
+		return defaultValue
+	}
+	return value.ValueString()
+}
+
+// int64OrDefault returns the value of value, or defaultValue when value is
+// null or unknown.
+func int64OrDefault(value types.Int64, defaultValue int64) int64 {
+	if value.IsNull() || value.IsUnknown() {
+		return defaultValue
+	}
+	return value.ValueInt64()
+}
+
+// nullStringIfEmpty returns null when value is the empty string, which the
+// SDK stored in state for strings that were not set.
+func nullStringIfEmpty(value types.String) types.String {
+	if !value.IsUnknown() && value.ValueString() == "" {
+		return types.StringNull()
+	}
+	return value
+}
+
+// nullInt64IfZero returns null when value is zero, which the SDK stored in
+// state for numbers that were not set.
+func nullInt64IfZero(value types.Int64) types.Int64 {
+	if !value.IsUnknown() && value.ValueInt64() == 0 {
+		return types.Int64Null()
+	}
+	return value
+}
+
+// nullListIfEmpty returns null when value has no elements, which the SDK
+// stored in state for lists that were not set.
+func nullListIfEmpty(value types.List) types.List {
+	if !value.IsUnknown() && len(value.Elements()) == 0 {
+		return types.ListNull(value.ElementType(context.Background()))
+	}
+	return value
+}
+
+// nullMapIfEmpty returns null when value has no elements, which the SDK
+// stored in state for maps that were not set.
+func nullMapIfEmpty(value types.Map) types.Map {
+	if !value.IsUnknown() && len(value.Elements()) == 0 {
+		return types.MapNull(value.ElementType(context.Background()))
+	}
+	return value
+}
+
+// commandOutput formats the output of a command for diagnostics.
+func commandOutput(stdout string, stderr string) string {
+	var output []string
+	if stdout != "" {
+		output = append(output, fmt.Sprintf("stdout:\n%s", stdout))
+	}
+	if stderr != "" {
+		output = append(output, fmt.Sprintf("stderr:\n%s", stderr))
+	}
+	return strings.Join(output, "\n")
+}
+
+// parsePrivateKey parses privateKey, which is decrypted with passphrase when
+// it is not empty.
+func parsePrivateKey(privateKey string, passphrase string) (ssh.Signer, error) {
+	if passphrase != "" {
+		return ssh.ParsePrivateKeyWithPassphrase([]byte(privateKey), []byte(passphrase))
 	}
 	signer, err := ssh.ParsePrivateKey([]byte(privateKey))
 	if _, ok := err.(*ssh.PassphraseMissingError); ok {
@@ -79,3 +146,24 @@ func writeFileToHost(host string, filename string, content string, group string,
 	}()
 	session.Run(fmt.Sprintf("cat /dev/stdin | tee %s && chgrp %s %s && chown %s %s", filename, group, filename, user, filename))
 }
+
+func runCommandOnHost(host string, cmd string) {
+	sshClient, err := ssh.Dial("tcp", host, &ssh.ClientConfig{
+		User:            "root",
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Auth:            []ssh.AuthMethod{ssh.Password("password")},
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	session, err := sshClient.NewSession()
+	if err != nil {
+		panic(err)
+	}
+	defer session.Close()
+
+	if err := session.Run(cmd); err != nil {
+		panic(err)
+	}
+}
@@ -5,7 +5,7 @@ import (
 	"flag"
 	"log"
 
-	"github.com/hashicorp/terraform-plugin-sdk/v2/plugin"
+	"github.com/hashicorp/terraform-plugin-go/tfprotov5/tf5server"
 	"github.com/tenstad/terraform-provider-remote/internal/provider"
 )
 
@@ -34,15 +34,22 @@ func main() {
 	flag.BoolVar(&debugMode, "debug", false, "set to true to run the provider with support for debuggers like delve")
 	flag.Parse()
 
-	opts := &plugin.ServeOpts{ProviderFunc: provider.New(version)}
+	ctx := context.Background()
 
+	// The provider is served by both the SDK and the framework while it is
+	// migrated to the framework.
+	server, err := provider.NewMuxServer(ctx, version, provider.New(version)())
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	var serveOpts []tf5server.ServeOpt
 	if debugMode {
-		err := plugin.Debug(context.Background(), "registry.terraform.io/tenstad/remote", opts)
-		if err != nil {
-			log.Fatal(err.Error())
-		}
-		return
+		serveOpts = append(serveOpts, tf5server.WithManagedDebug())
 	}
 
-	plugin.Serve(opts)
+	err = tf5server.Serve("registry.terraform.io/tenstad/remote", server, serveOpts...)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
 }